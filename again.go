@@ -0,0 +1,75 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"launchpad.net/goyaml"
+)
+
+// lastOptions are the options of the user's last session, replayed by -again
+type lastOptions struct {
+	Profile   string `yaml:"profile,omitempty"`
+	Region    string `yaml:"region,omitempty"`
+	SSHConfig bool   `yaml:"ssh_config,omitempty"`
+}
+
+func lastOptionsPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".dockersshell", "last.yaml")
+}
+
+// saveLastOptions remembers the options a session was created with
+func saveLastOptions(opts sessionOptions) {
+	text, err := goyaml.Marshal(&lastOptions{opts.Profile, opts.Region, opts.SSHConfig})
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(lastOptionsPath()), 0700); err == nil {
+			err = ioutil.WriteFile(lastOptionsPath(), text, 0600)
+		}
+	}
+	if err != nil {
+		log.Print(msg("Unable to remember session options: %s\n", err))
+	}
+}
+
+// replay fills in the options of the last session for the flags not given
+// on the command line
+func (opts *sessionOptions) replay(fs *flag.FlagSet) {
+	text, err := ioutil.ReadFile(lastOptionsPath())
+	if err != nil {
+		log.Fatal(msg("No previous session to repeat"))
+	}
+	var last lastOptions
+	if err := goyaml.Unmarshal(text, &last); err != nil {
+		log.Fatal(msg("Unable to read previous session options: %s\n", err))
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["profile"] {
+		opts.Profile = last.Profile
+	}
+	if !set["region"] {
+		opts.Region = last.Region
+	}
+	if !set["ssh-config"] {
+		opts.SSHConfig = opts.SSHConfig || last.SSHConfig
+	}
+}
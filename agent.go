@@ -0,0 +1,130 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// labelAgent marks sessions the agent was copied into
+const labelAgent = "dockersshell.agent"
+
+// agentPath is where the agent is copied to in a session
+const agentPath = "/usr/local/bin/dockersshell-agent"
+
+// installAgent copies the agent binary at binary into a session and has it run
+// the image's first-boot script. The agent answers:
+//
+//	dockersshell-agent boot            run the first-boot script
+//	dockersshell-agent idle            print the seconds since input
+//	dockersshell-agent warn MESSAGE    show MESSAGE to logged in users
+//	dockersshell-agent export          write the workspace as a tar to stdout
+func installAgent(client *docker.Client, id string, binary string) error {
+	content, err := ioutil.ReadFile(binary)
+	if err != nil {
+		return err
+	}
+
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	header := &tar.Header{Name: path.Base(agentPath), Mode: 0755, Size: int64(len(content))}
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	writer.Write(content)
+	writer.Close()
+
+	upload := docker.UploadToContainerOptions{InputStream: &archive, Path: path.Dir(agentPath)}
+	if err := client.UploadToContainer(id, upload); err != nil {
+		return err
+	}
+	return execRoot(client, id, []string{agentPath, "boot"})
+}
+
+// agentRun runs the agent in a session and returns what it printed
+func agentRun(client *docker.Client, id string, args ...string) (string, error) {
+	var output bytes.Buffer
+	if err := agentStream(client, id, &output, args...); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(output.String()), nil
+}
+
+// agentStream runs the agent in a session, writing its output to out
+func agentStream(client *docker.Client, id string, out io.Writer, args ...string) error {
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		Container:    id,
+		User:         "root",
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          append([]string{agentPath}, args...),
+	})
+	if err != nil {
+		return err
+	}
+
+	var errors bytes.Buffer
+	if err := client.StartExec(exec.ID, docker.StartExecOptions{OutputStream: out, ErrorStream: &errors}); err != nil {
+		return err
+	}
+	inspect, err := client.InspectExec(exec.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("agent exited with %d: %s", inspect.ExitCode, strings.TrimSpace(errors.String()))
+	}
+	return nil
+}
+
+// sessionIdle returns how long a session has been idle, as reported by its
+// agent, or - without one
+func sessionIdle(client *docker.Client, container docker.APIContainers) string {
+	if container.Labels[labelAgent] == "" {
+		return "-"
+	}
+	idle, err := agentRun(client, container.ID, "idle")
+	if err != nil {
+		return "?"
+	}
+	return idle + "s"
+}
+
+// runWorkspace writes the workspace of a session with an agent to stdout
+func runWorkspace(config *Config, user string, args []string) {
+	name := sessionName(findCommand("workspace"), args)
+	s := findSession(config.Endpoints, user, name)
+	if s == nil {
+		log.Fatal(msg("No session named %s", name))
+	}
+	if s.Container.Labels[labelAgent] == "" {
+		log.Fatal(msg("Session %s has no agent", name))
+	}
+
+	if err := agentStream(s.Client, s.Container.ID, os.Stdout, "export"); err != nil {
+		log.Fatal(msg("Unable to export workspace: %s\n", err))
+	}
+}
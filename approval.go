@@ -0,0 +1,82 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CleanupApprovalConfig has cleanup ask a webhook before removing expired
+// sessions carrying the tags, so that long running investigations can be
+// kept past max_age. Unreachable says what to do when the webhook cannot
+// answer: keep the sessions, the default, or remove them.
+type CleanupApprovalConfig struct {
+	Tags        tagFlags `yaml:"tags,omitempty"`
+	Webhook     string   `yaml:"webhook,omitempty"`
+	Unreachable string   `yaml:"unreachable,omitempty"`
+}
+
+// vetoError is the webhook refusing a removal, as opposed to failing to
+// answer
+type vetoError struct{ reason string }
+
+func (e *vetoError) Error() string { return e.reason }
+
+// applies reports whether removing a session with labels needs approval
+func (c *CleanupApprovalConfig) applies(labels map[string]string) bool {
+	return c.Webhook != "" && len(c.Tags) != 0 && matchTags(labels, c.Tags)
+}
+
+var approvalClient = &http.Client{Timeout: 10 * time.Second}
+
+// approveRemoval asks the webhook whether an expired session may be removed.
+// A 2xx response approves it. A 5xx response or no response at all is an
+// error, and any other response a vetoError with the response body as the
+// reason.
+func (c *CleanupApprovalConfig) approveRemoval(labels map[string]string, endpoint string, age int64) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"owner":    labels[labelOwner],
+		"session":  labels[labelName],
+		"endpoint": endpoint,
+		"age":      age,
+		"tags":     sessionTags(labels),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := approvalClient.Post(c.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 5 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	if resp.StatusCode/100 != 2 {
+		text, _ := ioutil.ReadAll(resp.Body)
+		if message := strings.TrimSpace(string(text)); message != "" {
+			return &vetoError{message}
+		}
+		return &vetoError{fmt.Sprintf("webhook returned %s", resp.Status)}
+	}
+	return nil
+}
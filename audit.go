@@ -0,0 +1,223 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// eventConnect and eventDenied are only recorded by the audit log
+const (
+	eventConnect = "connect"
+	eventDenied  = "denied"
+)
+
+type AuditConfig struct {
+	Address string `yaml:"address,omitempty"`
+	Format  string `yaml:"format,omitempty"`
+	TLS     bool   `yaml:"tls,omitempty"`
+	Spool   string `yaml:"spool,omitempty"`
+}
+
+// auditDir holds the spools of users other than root. It is one of the
+// userDirs, so that every user can spool their events without being able to
+// touch anyone else's.
+func auditDir(config *Config) string {
+	return filepath.Join(config.stateDir(), "audit")
+}
+
+// auditSpool returns where events are spooled and who the spool has to be
+// owned by, if anyone: root spools in state_dir itself, everyone else in
+// their own file in the auditDir
+func (c *Config) auditSpool() (string, string) {
+	if c.Audit.Spool != "" {
+		return c.Audit.Spool, ""
+	}
+	if os.Geteuid() == 0 {
+		return filepath.Join(c.stateDir(), "audit.spool"), ""
+	}
+	owner := currentUser()
+	return filepath.Join(auditDir(c), owner+".spool"), owner
+}
+
+// openSpool opens the spool, or the file it was claimed as, checking that a
+// user's spool is a file of their own in the auditDir
+func openSpool(path string, owner string, flag int) (*os.File, error) {
+	if owner == "" {
+		os.MkdirAll(filepath.Dir(path), 0700)
+		return openNoFollow(path, flag, 0600)
+	}
+	if err := checkUserDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	f, err := openUserFile(path, owner, flag)
+	if err == nil && flag&os.O_CREATE != 0 {
+		f.Chmod(0600)
+	}
+	return f, err
+}
+
+// auditTimeout bounds how long shipping an event may hold up the command
+// that records it
+const auditTimeout = 3 * time.Second
+
+var cefEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`)
+
+// cefKeys maps audit fields to CEF extension keys
+var cefKeys = map[string]string{
+	"user":          "suser",
+	"endpoint":      "dhost",
+	"session":       "cs1",
+	"detail":        "msg",
+	"cpu_seconds":   "cfp1",
+	"peak_memory":   "cn1",
+	"cost":          "cfp2",
+	"justification": "cs2",
+	"ticket":        "cs3",
+	"profile":       "cs4",
+	"method":        "cs5",
+	"reason":        "reason",
+}
+
+// cefLabels names the CEF custom extension keys used by cefKeys
+var cefLabels = map[string]string{
+	"cs1":  "session",
+	"cfp1": "cpuSeconds",
+	"cn1":  "peakMemory",
+	"cfp2": "cost",
+	"cs2":  "justification",
+	"cs3":  "ticket",
+	"cs4":  "profile",
+	"cs5":  "method",
+}
+
+// cefKey turns an audit field without a CEF key into one, as CEF keys may
+// only hold letters and digits
+func cefKey(field string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, field)
+}
+
+func formatAudit(format string, event string, fields map[string]string) string {
+	now := time.Now()
+	if format == "cef" {
+		var keys []string
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		extension := []string{fmt.Sprintf("rt=%d", now.UnixNano()/int64(time.Millisecond))}
+		for _, key := range keys {
+			name, ok := cefKeys[key]
+			if !ok {
+				name = cefKey(key)
+			}
+			extension = append(extension, name+"="+cefEscaper.Replace(fields[key]))
+			if label, ok := cefLabels[name]; ok {
+				extension = append(extension, name+"Label="+label)
+			}
+		}
+		return fmt.Sprintf("CEF:0|sivel|dockersshell|1.0|%s|session %s|3|%s", event, event, strings.Join(extension, " "))
+	}
+
+	record := map[string]string{"time": now.UTC().Format(time.RFC3339), "event": event}
+	for key, value := range fields {
+		record[key] = value
+	}
+	text, _ := json.Marshal(record)
+	return string(text)
+}
+
+func sendAudit(c *AuditConfig, lines []string, deadline time.Time) error {
+	dialer := &net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	var err error
+	if c.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.Address, &tls.Config{})
+	} else {
+		conn, err = dialer.Dial("tcp", c.Address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(deadline)
+	_, err = conn.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	return err
+}
+
+// audit ships an event to the SIEM collector, retrying for at most
+// auditTimeout. Events that cannot be delivered are spooled and sent ahead
+// of the next event. The spool is renamed before it
+// is read, so that concurrent commands do not send or drop each other's
+// events.
+func audit(config *Config, event string, fields map[string]string) {
+	c := &config.Audit
+	if c.Address == "" {
+		return
+	}
+
+	var lines []string
+	spool, owner := config.auditSpool()
+	claimed := fmt.Sprintf("%s.%d", spool, os.Getpid())
+	if os.Rename(spool, claimed) == nil {
+		if f, err := openSpool(claimed, owner, os.O_RDONLY); err == nil {
+			text, _ := ioutil.ReadAll(f)
+			f.Close()
+			lines = strings.Split(strings.TrimRight(string(text), "\n"), "\n")
+		}
+		defer os.Remove(claimed)
+	}
+	lines = append(lines, formatAudit(c.Format, event, fields))
+
+	deadline := time.Now().Add(auditTimeout)
+	var err error
+	for time.Now().Before(deadline) {
+		if err = sendAudit(c, lines, deadline); err == nil {
+			return
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	log.Print(msg("Unable to ship audit event, spooling it: %s\n", err))
+	f, err := openSpool(spool, owner, os.O_WRONLY|os.O_APPEND|os.O_CREATE)
+	if err == nil {
+		_, err = f.WriteString(strings.Join(lines, "\n") + "\n")
+		f.Close()
+	}
+	if err != nil {
+		log.Print(msg("Unable to spool audit events, %d are lost: %s\n", len(lines), err))
+		for _, line := range lines {
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatAuditCEF(t *testing.T) {
+	tests := []struct {
+		fields map[string]string
+		want   string
+	}{
+		{map[string]string{"user": "alice"}, "suser=alice"},
+		{map[string]string{"detail": `a=b\c`}, `msg=a\=b\\c`},
+		{map[string]string{"detail": "one\ntwo"}, `msg=one\ntwo`},
+		{map[string]string{"session": "web"}, "cs1=web cs1Label=session"},
+		{map[string]string{"profile": "gpu"}, "cs4=gpu cs4Label=profile"},
+		{map[string]string{"reason": "not the owner"}, "reason=not the owner"},
+		{map[string]string{"exit_code": "1"}, "exitcode=1"},
+	}
+	for _, test := range tests {
+		line := formatAudit("cef", "denied", test.fields)
+		if !strings.HasPrefix(line, "CEF:0|sivel|dockersshell|1.0|denied|session denied|3|rt=") {
+			t.Errorf("formatAudit(%v) = %q, want a CEF header", test.fields, line)
+		}
+		if !strings.HasSuffix(line, " "+test.want) {
+			t.Errorf("formatAudit(%v) = %q, want it to end with %q", test.fields, line, test.want)
+		}
+	}
+}
+
+func TestCEFKey(t *testing.T) {
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"method", "method"},
+		{"exit_code", "exitcode"},
+		{"peak-memory", "peakmemory"},
+		{"a b=c", "abc"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := cefKey(test.field); got != test.want {
+			t.Errorf("cefKey(%q) = %q, want %q", test.field, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// labelBatch marks the sessions provisioned together by batch, so they can
+// be torn down together
+const labelBatch = "dockersshell.batch"
+
+// rosterEntry is a line of a batch roster: the student, the profile of their
+// session and their public key, given inline or as the path to a key file
+type rosterEntry struct {
+	User    string
+	Profile string
+	Key     string
+}
+
+func readRoster(path string) ([]rosterEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var roster []rosterEntry
+	for _, record := range records {
+		entry := rosterEntry{User: record[0]}
+		if len(record) > 1 {
+			entry.Profile = record[1]
+		}
+		if len(record) > 2 {
+			entry.Key = record[2]
+		}
+		// Keys are "type base64 [comment]", anything else is a key file
+		if entry.Key != "" && !strings.Contains(entry.Key, " ") {
+			text, err := ioutil.ReadFile(entry.Key)
+			if err != nil {
+				return nil, err
+			}
+			entry.Key = strings.TrimSpace(string(text))
+		}
+		roster = append(roster, entry)
+	}
+	return roster, nil
+}
+
+// runBatch provisions a session for every student on a roster, for classes
+// and workshops, and prints how to reach them as CSV. The sessions are owned
+// by whoever runs the batch and are named after it and the student.
+func runBatch(config *Config, user string, args []string) {
+	fs := findCommand("batch").flags()
+	name := fs.String("name", "", "Name of the batch")
+	roster := fs.String("roster", "", "CSV file of user, profile and public key")
+	profile := fs.String("profile", "", "Profile for students without one on the roster")
+	teardown := fs.Bool("teardown", false, "Remove every session of the batch")
+	reason := fs.String("reason", "", "Why the sessions are needed, when a justification is required")
+	ticket := fs.String("ticket", "", "Ticket the sessions are for, when a justification is required")
+	fs.Parse(args)
+
+	if *name == "" || (*roster == "") == !*teardown {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *teardown {
+		for _, s := range listSessions(config.Endpoints, user) {
+			if s.Container.Labels[labelBatch] == *name {
+				destroySession(config, s.Endpoint, s.Client, s.Container.ID, s.Container.Labels[labelName], -1)
+			}
+		}
+		return
+	}
+
+	entries, err := readRoster(*roster)
+	if err != nil {
+		log.Fatal(msg("Unable to read roster: %s\n", err))
+	}
+
+	out := csv.NewWriter(os.Stdout)
+	out.Write([]string{"user", "session", "host", "port", "login"})
+	for _, entry := range entries {
+		if entry.Profile == "" {
+			entry.Profile = *profile
+		}
+		opts := sessionOptions{
+			Name:    *name + "-" + entry.User,
+			Profile: entry.Profile,
+			Labels:  map[string]string{labelBatch: *name},
+			Reason:  *reason,
+			Ticket:  *ticket,
+		}
+		endpoint, client, container := createSession(config, user, opts)
+		journalEnd(dockerName(container))
+
+		login := sessionProfile(config, container.Config.Labels).User
+		if entry.Key != "" {
+			if err := execRoot(client, container.ID, []string{"sh", "-c", authorizeKeys, "sh", login, entry.Key}); err != nil {
+				log.Print(msg("Unable to add the key of %s: %s\n", entry.User, err))
+			}
+		}
+
+		host, port := sessionAddress(endpoint, client, container.ID)
+		out.Write([]string{entry.User, opts.Name, host, port, login})
+		out.Flush()
+	}
+}
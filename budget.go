@@ -0,0 +1,117 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// BudgetConfig caps what the running sessions of a user, or of all members
+// of a unix group, may cost per hour
+type BudgetConfig struct {
+	User  float64            `yaml:"user,omitempty"`
+	Teams map[string]float64 `yaml:"teams,omitempty"`
+}
+
+// hourlyCost returns the configured hourly cost of a session on endpoint
+func hourlyCost(endpoint string) float64 {
+	return endpointConfigs[endpoint].HourlyCost
+}
+
+// sessionCost estimates what a session has cost since it was created
+func sessionCost(endpoint string, created time.Time) string {
+	return fmt.Sprintf("%.2f", time.Since(created).Hours()*hourlyCost(endpoint))
+}
+
+// hasCosts reports whether any endpoint has an hourly cost configured
+func hasCosts() bool {
+	for _, settings := range endpointConfigs {
+		if settings.HourlyCost != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// spending returns the hourly cost of the running sessions of every owner
+func spending(endpoints []string) map[string]float64 {
+	spend := map[string]float64{}
+	for _, endpoint := range endpoints {
+		client, err := dockerClient(endpoint)
+		if err != nil {
+			continue
+		}
+		containers, err := managedContainers(client)
+		if err != nil {
+			continue
+		}
+		for _, container := range containers {
+			poolLabels(&container)
+			if owner := container.Labels[labelOwner]; owner != "" {
+				spend[owner] += hourlyCost(endpoint)
+			}
+		}
+	}
+	return spend
+}
+
+// applyBudget rules out the endpoints where one more session would take
+// user, or one of their teams, over its hourly budget
+func applyBudget(config *Config, states []endpointState, user string) {
+	budget := config.Budget
+	if budget.User == 0 && len(budget.Teams) == 0 {
+		return
+	}
+
+	spend := spending(config.Endpoints)
+	limits := map[string]float64{}
+	if budget.User != 0 {
+		limits[user] = spend[user] - budget.User
+	}
+
+	if len(budget.Teams) != 0 {
+		groups, _ := userGroups(user)
+		for _, group := range groups {
+			limit, ok := budget.Teams[group]
+			if !ok {
+				continue
+			}
+
+			// What the team spends is what its members spend
+			var team float64
+			for owner, cost := range spend {
+				if memberships, err := userGroups(owner); err == nil {
+					for _, g := range memberships {
+						if g == group {
+							team += cost
+						}
+					}
+				}
+			}
+			limits["team "+group] = team - limit
+		}
+	}
+
+	for i := range states {
+		cost := hourlyCost(states[i].Endpoint)
+		for _, over := range limits {
+			if over+cost > 0 {
+				states[i].Down = true
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"launchpad.net/goyaml"
+)
+
+// cacheTTL is how long connect trusts a cached session without looking it up
+const cacheTTL = time.Hour
+
+// cachedConnection is how to reach one of the user's sessions, kept so
+// connect can skip the endpoint lookups
+type cachedConnection struct {
+	Endpoint string            `yaml:"endpoint"`
+	ID       string            `yaml:"id"`
+	Host     string            `yaml:"host"`
+	Port     string            `yaml:"port"`
+	Labels   map[string]string `yaml:"labels,omitempty"`
+	Cached   int64             `yaml:"cached"`
+}
+
+func sessionCachePath() string {
+	return filepath.Join(os.Getenv("HOME"), ".dockersshell", "sessions.yaml")
+}
+
+func loadSessionCache() map[string]cachedConnection {
+	cache := map[string]cachedConnection{}
+	if text, err := ioutil.ReadFile(sessionCachePath()); err == nil {
+		goyaml.Unmarshal(text, &cache)
+	}
+	return cache
+}
+
+// saveSessionCache writes the cache. It is only an optimisation, so failures
+// are ignored.
+func saveSessionCache(cache map[string]cachedConnection) {
+	text, err := goyaml.Marshal(cache)
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(sessionCachePath()), 0700) == nil {
+		ioutil.WriteFile(sessionCachePath(), text, 0600)
+	}
+}
+
+func cacheSession(name string, endpoint string, id string, host string, port string, labels map[string]string) {
+	cache := loadSessionCache()
+	cache[name] = cachedConnection{endpoint, id, host, port, labels, time.Now().Unix()}
+	saveSessionCache(cache)
+}
+
+func uncacheSession(name string) {
+	cache := loadSessionCache()
+	if _, ok := cache[name]; ok {
+		delete(cache, name)
+		saveSessionCache(cache)
+	}
+}
+
+// cachedSession returns the cached connection for name if it is fresh
+func cachedSession(name string) *cachedConnection {
+	c, ok := loadSessionCache()[name]
+	if !ok || time.Since(time.Unix(c.Cached, 0)) > cacheTTL {
+		return nil
+	}
+	return &c
+}
+
+// reconnect connects to a cached session straight away, relying on its host
+// keys recorded when it was created rather than waiting for it and reading
+// them again. It reports false when ssh could not connect, for the session
+// to be looked up again. Sessions reached any other way than ssh, and shared
+// sessions, are always looked up.
+func reconnect(config *Config, name string, c *cachedConnection) bool {
+	profile := sessionProfile(config, c.Labels)
+	if len(profile.Connect) != 0 && profile.Connect[0] != "ssh" || c.Labels[labelShared] != "" {
+		return false
+	}
+	if len(knownHostKeys(c.Host, c.Port)) == 0 {
+		return false
+	}
+
+	status(colorGreen, "Connecting to %s:%s with ssh", c.Host, c.Port)
+	audit(config, eventConnect, map[string]string{"user": invoker, "session": name, "endpoint": c.Host, "method": "ssh"})
+	done := make(chan bool)
+	if config.Warnings {
+		if client, err := dockerClient(c.Endpoint); err == nil {
+			go watchResources(client, c.ID, done)
+		}
+	}
+	_, err := connect(&config.SSHClient, c.Endpoint, profile.User, c.Host, c.Port, true, config.userEnv())
+	close(done)
+	return err == nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// cleanResult is the outcome of cleaning up one container, or of an
+// endpoint that could not be cleaned up at all
+type cleanResult struct {
+	Status   string
+	Name     string
+	Endpoint string
+	Reason   string
+}
+
+// cleanEndpoint removes containers older than max_age from an endpoint and
+// emails the owners of those about to be removed. It carries on past
+// failures and reports on every session.
+func cleanEndpoint(config *Config, endpoint string, tags tagFlags, notices *noticeState, lock *sync.Mutex) []cleanResult {
+	client, err := dockerClient(endpoint)
+	if err != nil {
+		return []cleanResult{{"failed", "-", endpoint, err.Error()}}
+	}
+
+	containers, err := managedContainers(client)
+	if err != nil {
+		return []cleanResult{{"failed", "-", endpoint, err.Error()}}
+	}
+
+	fillPool(config, client, endpoint)
+
+	results := purgeDeleted(config, client, endpoint)
+	for _, container := range containers {
+		if len(container.Names) != 1 {
+			continue
+		}
+		parts := strings.Split(container.Names[0], "-")
+		if len(parts) != 2 {
+			continue
+		}
+		created, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		name := strings.TrimPrefix(container.Names[0], "/")
+		if !matchTags(container.Labels, tags) {
+			lock.Lock()
+			notices.seen[container.ID] = true
+			lock.Unlock()
+			continue
+		}
+		if config.MaxAge == 0 {
+			results = append(results, cleanResult{"skipped", name, endpoint, "max_age is not set"})
+			continue
+		}
+
+		age := time.Now().Unix() - created
+		if age > int64(config.MaxAge) && config.CleanupApproval.applies(container.Labels) {
+			err := config.CleanupApproval.approveRemoval(container.Labels, endpoint, age)
+			if _, veto := err.(*vetoError); err != nil && !veto && config.CleanupApproval.Unreachable == "remove" {
+				log.Print(msg("Unable to ask for approval to remove %s, removing it anyway: %s\n", name, err))
+			} else if err != nil {
+				status := "deferred"
+				if !veto {
+					status = "failed"
+					err = fmt.Errorf("%s", msg("Unable to ask for approval: %s", err))
+				}
+				results = append(results, cleanResult{status, name, endpoint, err.Error()})
+				lock.Lock()
+				notices.seen[container.ID] = true
+				lock.Unlock()
+				continue
+			}
+		}
+		if age > int64(config.MaxAge) {
+			fields := map[string]string{"user": container.Labels[labelOwner], "session": container.Names[0], "endpoint": endpoint}
+			for key, value := range sessionUsage(client, container.ID) {
+				fields[key] = value
+			}
+			if hourlyCost(endpoint) != 0 {
+				fields["cost"] = sessionCost(endpoint, time.Unix(created, 0))
+			}
+
+			if err := client.StopContainer(container.ID, 0); err != nil {
+				results = append(results, cleanResult{"failed", name, endpoint, msg("Unable to stop container: %s", err)})
+				continue
+			}
+
+			remove := docker.RemoveContainerOptions{ID: container.ID, RemoveVolumes: false}
+			if err := client.RemoveContainer(remove); err != nil {
+				results = append(results, cleanResult{"failed", name, endpoint, msg("Unable to remove container: %s", err)})
+				continue
+			}
+			results = append(results, cleanResult{"removed", name, endpoint, fmt.Sprintf("age %ds", age)})
+			deregisterSession(config, container.Labels[labelOwner], container.Labels[labelName])
+			recordHistory(config, historyEntry{
+				Owner:    container.Labels[labelOwner],
+				Name:     container.Labels[labelName],
+				Profile:  container.Labels[labelProfile],
+				Endpoint: endpoint,
+				Start:    created,
+				End:      time.Now().Unix(),
+				ExitCode: -1,
+			})
+			notify(config, eventCleanup, "Cleaned up %s on %s", container.Names[0], endpoint)
+			audit(config, eventCleanup, fields)
+			continue
+		}
+		results = append(results, cleanResult{"skipped", name, endpoint, fmt.Sprintf("expires in %ds", int64(config.MaxAge)-age)})
+
+		lock.Lock()
+		notices.seen[container.ID] = true
+		notified := notices.notified[container.ID]
+		lock.Unlock()
+		agent := container.Labels[labelAgent] != ""
+		if (config.SMTP.Server != "" || agent) && !notified && age > int64(config.MaxAge)-config.SMTP.notifyBefore() {
+			owner := container.Labels[labelOwner]
+			if owner == "" {
+				owner = strings.TrimPrefix(parts[0], "/")
+			}
+			removal := time.Unix(created+int64(config.MaxAge), 0)
+
+			// Users logged in to sessions with an agent are warned there too
+			if agent {
+				warning := msg("This session will be removed at %s.", removal.Format(time.RFC1123))
+				if _, err := agentRun(client, container.ID, "warn", warning); err != nil {
+					log.Print(msg("Unable to warn %s: %s\n", owner, err))
+				}
+			}
+			if config.SMTP.Server != "" {
+				if err := sendCleanupNotice(&config.SMTP, owner, container.Names[0], endpoint, removal); err != nil {
+					log.Print(msg("Unable to notify %s: %s\n", owner, err))
+					continue
+				}
+			}
+			lock.Lock()
+			notices.notified[container.ID] = true
+			lock.Unlock()
+		}
+	}
+	return results
+}
+
+// cleanup starts and stops scheduled sessions, then cleans up every endpoint
+// in parallel, printing a line for every session and a summary. It returns
+// false when anything failed.
+func cleanup(config *Config, tags tagFlags) bool {
+	runSchedules(config)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	notices := loadNoticeState(config.stateDir())
+	results := make([][]cleanResult, len(config.Endpoints))
+	for i, endpoint := range config.Endpoints {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			results[i] = cleanEndpoint(config, endpoint, tags, notices, &lock)
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	counts := map[string]int{}
+	for _, endpointResults := range results {
+		for _, result := range endpointResults {
+			counts[result.Status]++
+			fmt.Printf("%-8s %-30s %-30s %s\n", result.Status, result.Name, result.Endpoint, result.Reason)
+		}
+	}
+	fmt.Println(msg("%d removed, %d skipped, %d deferred, %d failed", counts["removed"], counts["skipped"], counts["deferred"], counts["failed"]))
+
+	if config.SMTP.Server != "" || len(notices.notified) != 0 {
+		if err := notices.save(); err != nil {
+			log.Print(msg("Unable to save notification state: %s\n", err))
+		}
+	}
+	return counts["failed"] == 0
+}
@@ -0,0 +1,328 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"launchpad.net/goyaml"
+)
+
+type command struct {
+	Name     string
+	Args     string
+	Short    string
+	Complete string
+	Run      func(config *Config, user string, args []string)
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"create", "[-name NAME] [-profile PROFILE] [-region REGION] [-ssh-config] [-again] [-timeout DURATION] [-tag KEY=VALUE]... [-reason REASON] [-ticket TICKET]", "Create a session and print how to reach it", "", runCreate},
+		{"ensure", "-name NAME [-profile PROFILE] [-region REGION] [-ssh-config] [-again] [-timeout DURATION] [-tag KEY=VALUE]... [-reason REASON] [-ticket TICKET]", "Create a session unless it exists and print how to reach it", "", runEnsure},
+		{"connect", "NAME", "Connect to a running session", "sessions", runConnect},
+		{"list", "[-tag KEY=VALUE]...", "List your running sessions", "", runList},
+		{"kill", "[-owner USER] NAME", "Remove a running session", "sessions", runKill},
+		{"prune", "[-dry-run]", "Remove ssh config and known hosts entries of sessions that are gone", "", runPrune},
+		{"undelete", "[-reason REASON] [-ticket TICKET] [NAME]", "Restore a removed session, or list those that can be", "", runUndelete},
+		{"history", "[-profile PROFILE] [-endpoint ENDPOINT] [-since DURATION] [-user USER | -all]", "List your finished sessions", "", runHistory},
+		{"clean", "[-tag KEY=VALUE]...", "Clean up containers older than max_age", "", runClean},
+		{"inventory", "[-all] [--list] [--host HOST]", "Print running sessions as Ansible dynamic inventory", "", runInventory},
+		{"pull", "", "Pull the images of every profile on every endpoint", "", runPull},
+		{"rollout", "", "Show which image every running session uses", "", runRollout},
+		{"migrate", "NAME [ENDPOINT]", "Move a session to another endpoint", "sessions", runMigrate},
+		{"rebalance", "[-apply]", "Move sessions back to their affinity endpoint", "", runRebalance},
+		{"drain", "[-undo] [-notify MESSAGE] [-migrate] [ENDPOINT]", "Stop placing sessions on an endpoint and list its sessions", "endpoints", runDrain},
+		{"schedule", "-name NAME [-profile PROFILE] [-region REGION] [-every DURATION] [-reason REASON] [-ticket TICKET] START DURATION | -list | -cancel -name NAME", "Request a session for a future time window", "", runSchedule},
+		{"batch", "-name NAME -roster FILE [-profile PROFILE] [-reason REASON] [-ticket TICKET] | -teardown -name NAME", "Provision a session for every student on a roster", "", runBatch},
+		{"workspace", "NAME", "Write the workspace of a session with an agent as a tar to stdout", "sessions", runWorkspace},
+		{"stats", "[NAME]", "Stream resource usage of your sessions", "sessions", runStats},
+		{"logs", "[-f] [-tail N] [-timestamps] [-owner USER] NAME", "Write the output of a session's container", "sessions", runLogs},
+		{"top", "[-interval DURATION] [-once] [-tag KEY=VALUE]...", "Show resource usage of every session on every endpoint", "", runTop},
+		{"simulate", "[-user USER] STATES", "Print the placement decision for fake endpoint states", "files", runSimulate},
+		{"init", "[-force]", "Write a user config interactively", "", runInit},
+		{"setup", "", "Create the state directories users write to, as root", "", runSetup},
+		{"import", "[-ssh-hosts PATTERN] [-port PORT] [-write] | [-name NAME] [-region REGION] [-ssh-config] FILE", "Import endpoints from docker contexts and ssh config, or recreate an exported session", "", runImport},
+		{"export", "[-json] NAME", "Write a portable definition of a session", "sessions", runExport},
+		{"recover", "", "Remove sessions left behind by crashed runs", "", runRecover},
+		{"config", "", "Print the effective configuration", "", runConfig},
+		{"self-update", "[-force]", "Replace dockersshell with the latest signed release", "", runSelfUpdate},
+		{"completion", "bash|zsh|fish", "Print a shell completion script", "shells", runCompletion},
+		{"help", "[COMMAND]", "Show help for a command", "commands", runHelp},
+		{"__complete", "WORDS", "", "", runComplete},
+		{"__proxy", "ENDPOINT HOST PORT", "", "", runProxy},
+		{"__scheduled", "start|stop|skip FILE START", "", "", runScheduledAction},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: dockersshell [flags] [command] [args]\n\n")
+	fmt.Fprintf(os.Stderr, "Without a command, a session is created, connected to and removed on exit.\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, cmd := range commands {
+		if cmd.Short != "" {
+			fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.Name, cmd.Short)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nEnvironment:\n")
+	fmt.Fprintf(os.Stderr, "  DSSHUSER   The user sessions are created for, exported to ssh, which sends it\n")
+	fmt.Fprintf(os.Stderr, "             to sessions that accept it, and to the session environment.\n")
+	fmt.Fprintf(os.Stderr, "             Renamed with user_env.\n")
+}
+
+// flags returns a flag set for cmd whose usage describes the command
+func (cmd *command) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dockersshell %s %s\n\n%s\n", cmd.Name, cmd.Args, cmd.Short)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// sessionName parses args for cmd and returns its single NAME argument
+func sessionName(cmd *command, args []string) string {
+	fs := cmd.flags()
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Arg(0)
+}
+
+func runCreate(config *Config, user string, args []string) {
+	var opts sessionOptions
+	fs := findCommand("create").flags()
+	fs.StringVar(&opts.Name, "name", "", "Human friendly name for the session")
+	fs.StringVar(&opts.Profile, "profile", "", "Profile to create the session from")
+	fs.StringVar(&opts.Region, "region", "", "Region to create the session in, instead of the closest")
+	fs.BoolVar(&opts.SSHConfig, "ssh-config", config.SSHConfig, "Add a Host entry for the session to ~/.ssh/dockersshell_config")
+	fs.DurationVar(&opts.Timeout, "timeout", 0, "Give up and remove the session if it is not ready within this long")
+	fs.StringVar(&opts.Reason, "reason", "", "Why you need the session, when a justification is required")
+	fs.StringVar(&opts.Ticket, "ticket", "", "Ticket the session is for, when a justification is required")
+	opts.Tags = tagFlags{}
+	fs.Var(opts.Tags, "tag", "Tag the session with KEY=VALUE, can be repeated")
+	again := fs.Bool("again", false, "Repeat the profile, region and ssh config of your last session")
+	fs.Parse(args)
+	if *again {
+		opts.replay(fs)
+	}
+
+	if opts.Name != "" {
+		if s := findSession(config.Endpoints, user, opts.Name); s != nil {
+			log.Fatal(msg("Session %s already exists on %s", opts.Name, s.Endpoint))
+		}
+	}
+
+	provision(config, user, opts)
+}
+
+// provision creates a session in the background and prints its name, host
+// and port
+func provision(config *Config, user string, opts sessionOptions) {
+	endpoint, client, container := createSession(config, user, opts)
+	saveLastOptions(opts)
+	name := container.Config.Labels[labelName]
+	host, port := sessionAddress(endpoint, client, container.ID)
+
+	// sshd generates its host keys when it first starts
+	wait(endpoint, host, port)
+	if publishHostKeys(client, container.ID, host, port) {
+		cacheSession(name, endpoint, container.ID, host, port, container.Config.Labels)
+	}
+	stopDeadline()
+
+	if opts.SSHConfig {
+		if err := addSSHConfigEntry(name, endpoint, sessionProfile(config, container.Config.Labels).User, host, port); err != nil {
+			fmt.Print(msg("Unable to update ssh config: %s\n", err))
+		}
+	}
+
+	fmt.Printf("%s %s %s\n", name, host, port)
+	journalEnd(dockerName(container))
+}
+
+// runEnsure is create for configuration management: an existing session of
+// the same name is reported exactly as if it had just been created
+func runEnsure(config *Config, user string, args []string) {
+	var opts sessionOptions
+	fs := findCommand("ensure").flags()
+	fs.StringVar(&opts.Name, "name", "", "Name of the session")
+	fs.StringVar(&opts.Profile, "profile", "", "Profile to create the session from")
+	fs.StringVar(&opts.Region, "region", "", "Region to create the session in, instead of the closest")
+	fs.BoolVar(&opts.SSHConfig, "ssh-config", config.SSHConfig, "Add a Host entry for the session to ~/.ssh/dockersshell_config")
+	fs.DurationVar(&opts.Timeout, "timeout", 0, "Give up and remove the session if it is not ready within this long")
+	fs.StringVar(&opts.Reason, "reason", "", "Why you need the session, when a justification is required")
+	fs.StringVar(&opts.Ticket, "ticket", "", "Ticket the session is for, when a justification is required")
+	opts.Tags = tagFlags{}
+	fs.Var(opts.Tags, "tag", "Tag the session with KEY=VALUE, can be repeated")
+	again := fs.Bool("again", false, "Repeat the profile, region and ssh config of your last session")
+	fs.Parse(args)
+	if *again {
+		opts.replay(fs)
+	}
+
+	if opts.Name == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	s := findSession(config.Endpoints, user, opts.Name)
+	if s == nil {
+		provision(config, user, opts)
+		return
+	}
+
+	if profile := s.Container.Labels[labelProfile]; profile != opts.Profile {
+		status(colorYellow, "Session %s exists with profile %q, not %q", opts.Name, profile, opts.Profile)
+	}
+
+	host, port := sessionAddress(s.Endpoint, s.Client, s.Container.ID)
+	if opts.SSHConfig {
+		if err := addSSHConfigEntry(opts.Name, s.Endpoint, sessionProfile(config, s.Container.Labels).User, host, port); err != nil {
+			fmt.Print(msg("Unable to update ssh config: %s\n", err))
+		}
+	}
+
+	fmt.Printf("%s %s %s\n", opts.Name, host, port)
+}
+
+func runConnect(config *Config, user string, args []string) {
+	name := sessionName(findCommand("connect"), args)
+	if cached := cachedSession(name); cached != nil {
+		if reconnect(config, name, cached) {
+			return
+		}
+		status(colorYellow, "Looking %s up again", name)
+		uncacheSession(name)
+	}
+
+	s := findSession(config.Endpoints, user, name)
+	if s == nil {
+		log.Fatal(msg("No session named %s", name))
+	}
+
+	host, port := sessionAddress(s.Endpoint, s.Client, s.Container.ID)
+	cacheSession(name, s.Endpoint, s.Container.ID, host, port, s.Container.Labels)
+	attach(config, s.Endpoint, s.Client, s.Container.ID, name, s.Container.Labels, host, port)
+}
+
+func runList(config *Config, user string, args []string) {
+	fs := findCommand("list").flags()
+	tags := tagFlags{}
+	fs.Var(tags, "tag", "Only list sessions tagged KEY=VALUE, can be repeated")
+	fs.Parse(args)
+
+	fmt.Printf("%-30s %-30s %-20s %-8s %-25s %s\n", "NAME", "ENDPOINT", "CREATED", "IDLE", "TAGS", "STATUS")
+	for _, s := range listSessions(config.Endpoints, user) {
+		if !matchTags(s.Container.Labels, tags) {
+			continue
+		}
+		created := time.Unix(s.Container.Created, 0).Format("2006-01-02 15:04:05")
+		idle := sessionIdle(s.Client, s.Container)
+		fmt.Printf("%-30s %-30s %-20s %-8s %-25s %s\n", s.Container.Labels[labelName], s.Endpoint, created, idle, sessionTags(s.Container.Labels), s.Container.Status)
+	}
+}
+
+func runKill(config *Config, user string, args []string) {
+	fs := findCommand("kill").flags()
+	owner := fs.String("owner", user, "Owner of the session, for admins")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+
+	s := findSession(config.Endpoints, *owner, name)
+	if s == nil {
+		log.Fatal(msg("No session named %s", name))
+	}
+	if err := checkOwner(config, user, s.Container.Labels); err != nil {
+		log.Fatal(msg("Unable to remove %s: %s\n", name, err))
+	}
+
+	destroySession(config, s.Endpoint, s.Client, s.Container.ID, name, -1)
+}
+
+func runClean(config *Config, user string, args []string) {
+	fs := findCommand("clean").flags()
+	tags := tagFlags{}
+	fs.Var(tags, "tag", "Only clean up sessions tagged KEY=VALUE, can be repeated")
+	fs.Parse(args)
+	if !cleanup(config, tags) {
+		os.Exit(1)
+	}
+}
+
+func runPull(config *Config, user string, args []string) {
+	findCommand("pull").flags().Parse(args)
+	pullImages(config)
+}
+
+func runStats(config *Config, user string, args []string) {
+	fs := findCommand("stats").flags()
+	fs.Parse(args)
+	showStats(config.Endpoints, user, fs.Arg(0))
+}
+
+func runSimulate(config *Config, user string, args []string) {
+	fs := findCommand("simulate").flags()
+	owner := fs.String("user", "", "Place by affinity for this user")
+	fs.Parse(args)
+	simulate(fs.Arg(0), *owner)
+}
+
+func runConfig(config *Config, user string, args []string) {
+	findCommand("config").flags().Parse(args)
+
+	text, err := goyaml.Marshal(config)
+	if err != nil {
+		log.Fatal(msg("Unable to render config: %s\n", err))
+	}
+	os.Stdout.Write(text)
+}
+
+func runHelp(config *Config, user string, args []string) {
+	if len(args) == 0 {
+		usage()
+		return
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		log.Fatal(msg("Unknown command: %s", args[0]))
+	}
+	cmd.flags().Usage()
+}
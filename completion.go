@@ -0,0 +1,133 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// The completion scripts only hand the words typed so far to the hidden
+// __complete command, so candidates always reflect the current state of the
+// fleet and new commands need no script changes. The word after -profile is
+// completed with the profiles the user may use.
+var completionScripts = map[string]string{
+	"bash": `_dockersshell() {
+    local words
+    words=$(dockersshell __complete "${COMP_WORDS[@]:1:COMP_CWORD-1}" 2>/dev/null)
+    COMPREPLY=($(compgen -W "$words" -- "${COMP_WORDS[COMP_CWORD]}"))
+}
+complete -o default -F _dockersshell dockersshell
+`,
+	"zsh": `#compdef dockersshell
+_dockersshell() {
+    local -a candidates
+    candidates=(${(f)"$(dockersshell __complete ${words[2,CURRENT-1]} 2>/dev/null)"})
+    compadd -a candidates
+}
+compdef _dockersshell dockersshell
+`,
+	"fish": `complete -c dockersshell -f -a '(dockersshell __complete (commandline -opc)[2..-1] 2>/dev/null)'
+`,
+}
+
+func runCompletion(config *Config, user string, args []string) {
+	fs := findCommand("completion").flags()
+	fs.Parse(args)
+
+	script, ok := completionScripts[fs.Arg(0)]
+	if !ok {
+		fs.Usage()
+		log.Fatal(msg("Unsupported shell: %s", fs.Arg(0)))
+	}
+	fmt.Print(script)
+}
+
+// profileFlag reports whether word is the -profile flag, which takes the
+// word after it
+func profileFlag(word string) bool {
+	return strings.TrimLeft(word, "-") == "profile" && strings.HasPrefix(word, "-")
+}
+
+// profileNames returns the profiles user may create sessions from
+func profileNames(config *Config, user string) []string {
+	if assignedProfile != "" {
+		return []string{assignedProfile}
+	}
+	var names []string
+	for name := range config.Profiles {
+		if profile, err := config.profile(name); err == nil && profile.allowed(user) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completions returns the candidates for the word following words
+func completions(config *Config, user string, words []string) []string {
+	if len(words) != 0 && profileFlag(words[len(words)-1]) {
+		return profileNames(config, user)
+	}
+
+	var positional []string
+	for i, word := range words {
+		if !strings.HasPrefix(word, "-") && (i == 0 || !profileFlag(words[i-1])) {
+			positional = append(positional, word)
+		}
+	}
+
+	var candidates []string
+	if len(positional) == 0 {
+		for _, cmd := range commands {
+			if cmd.Short != "" {
+				candidates = append(candidates, cmd.Name)
+			}
+		}
+		return candidates
+	}
+
+	cmd := findCommand(positional[0])
+	if cmd == nil || len(positional) > 1 {
+		return nil
+	}
+
+	switch cmd.Complete {
+	case "sessions":
+		for _, s := range listSessions(config.Endpoints, user) {
+			candidates = append(candidates, s.Container.Labels[labelName])
+		}
+	case "profiles":
+		candidates = profileNames(config, user)
+	case "endpoints":
+		candidates = append(candidates, config.Endpoints...)
+	case "commands":
+		candidates = completions(config, user, nil)
+	case "shells":
+		for shell := range completionScripts {
+			candidates = append(candidates, shell)
+		}
+	}
+	return candidates
+}
+
+func runComplete(config *Config, user string, args []string) {
+	for _, candidate := range completions(config, user, args) {
+		fmt.Println(candidate)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type ConsulConfig struct {
+	Address string `yaml:"address,omitempty"`
+	Service string `yaml:"service,omitempty"`
+	Tag     string `yaml:"tag,omitempty"`
+}
+
+type DiscoveryConfig struct {
+	SRV    string       `yaml:"srv,omitempty"`
+	Consul ConsulConfig `yaml:"consul,omitempty"`
+	Scheme string       `yaml:"scheme,omitempty"`
+}
+
+func (c *DiscoveryConfig) endpoint(host string, port int) string {
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(strings.TrimSuffix(host, "."), fmt.Sprint(port)))
+}
+
+// discoverSRV returns an endpoint for every target of a DNS SRV record,
+// such as _docker._tcp.example.com
+func discoverSRV(c *DiscoveryConfig) ([]string, error) {
+	_, records, err := net.LookupSRV("", "", c.SRV)
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []string
+	for _, record := range records {
+		endpoints = append(endpoints, c.endpoint(record.Target, int(record.Port)))
+	}
+	return endpoints, nil
+}
+
+var consulClient = &http.Client{Timeout: 5 * time.Second}
+
+// discoverConsul returns an endpoint for every passing instance of a Consul
+// service
+func discoverConsul(c *DiscoveryConfig) ([]string, error) {
+	address := c.Consul.Address
+	if address == "" {
+		address = "http://127.0.0.1:8500"
+	}
+
+	query := url.Values{"passing": {"true"}}
+	if c.Consul.Tag != "" {
+		query.Set("tag", c.Consul.Tag)
+	}
+	resp, err := consulClient.Get(fmt.Sprintf("%s/v1/health/service/%s?%s", address, url.PathEscape(c.Consul.Service), query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul returned %s", resp.Status)
+	}
+
+	var entries []struct {
+		Node    struct{ Address string }
+		Service struct {
+			Address string
+			Port    int
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	var endpoints []string
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		endpoints = append(endpoints, c.endpoint(host, entry.Service.Port))
+	}
+	return endpoints, nil
+}
+
+// discoverEndpoints adds the endpoints found through DNS SRV and Consul to
+// the static ones. Discovery runs on every invocation, so the list is always
+// current.
+func discoverEndpoints(config *Config) {
+	seen := map[string]bool{}
+	for _, endpoint := range config.Endpoints {
+		seen[endpoint] = true
+	}
+
+	var discovered []string
+	if config.Discovery.SRV != "" {
+		endpoints, err := discoverSRV(&config.Discovery)
+		if err != nil {
+			log.Print(msg("Unable to discover endpoints from %s: %s\n", config.Discovery.SRV, err))
+		}
+		discovered = append(discovered, endpoints...)
+	}
+	if config.Discovery.Consul.Service != "" {
+		endpoints, err := discoverConsul(&config.Discovery)
+		if err != nil {
+			log.Print(msg("Unable to discover endpoints from consul: %s\n", err))
+		}
+		discovered = append(discovered, endpoints...)
+	}
+
+	for _, endpoint := range discovered {
+		if !seen[endpoint] {
+			seen[endpoint] = true
+			config.Endpoints = append(config.Endpoints, endpoint)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// DNSConfig registers sessions in a zone by dynamic DNS update (RFC 2136)
+// with nsupdate
+type DNSConfig struct {
+	Zone   string `yaml:"zone,omitempty"`
+	Server string `yaml:"server,omitempty"`
+	Key    string `yaml:"key,omitempty"`
+	TTL    int    `yaml:"ttl,omitempty"`
+}
+
+// sessionFQDN returns the stable name of a session of owner
+func (c *DNSConfig) sessionFQDN(owner string, name string) string {
+	return fmt.Sprintf("%s.%s.%s.", dnsLabel(name), dnsLabel(owner), strings.TrimSuffix(c.Zone, "."))
+}
+
+// nsupdate sends the update commands to the zone's server
+func (c *DNSConfig) nsupdate(commands []string) error {
+	var args []string
+	if c.Key != "" {
+		args = append(args, "-k", c.Key)
+	}
+
+	script := []string{"zone " + c.Zone}
+	if c.Server != "" {
+		script = append([]string{"server " + c.Server}, script...)
+	}
+	script = append(script, commands...)
+	script = append(script, "send")
+
+	var output bytes.Buffer
+	cmd := exec.Command("nsupdate", args...)
+	cmd.Stdin = strings.NewReader(strings.Join(script, "\n") + "\n")
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %s", err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// registerSession points the session's name at its host, and an
+// _ssh._tcp SRV record under it at its host and port
+func registerSession(config *Config, owner string, name string, host string, port string) {
+	c := &config.DNS
+	if c.Zone == "" {
+		return
+	}
+
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = 60
+	}
+	fqdn := c.sessionFQDN(owner, name)
+	record := fmt.Sprintf("CNAME %s.", strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		record = "A " + host
+		if ip.To4() == nil {
+			record = "AAAA " + host
+		}
+	}
+
+	err := c.nsupdate([]string{
+		"update delete " + fqdn,
+		fmt.Sprintf("update add %s %d %s", fqdn, ttl, record),
+		"update delete _ssh._tcp." + fqdn,
+		fmt.Sprintf("update add _ssh._tcp.%s %d SRV 0 0 %s %s", fqdn, ttl, port, fqdn),
+	})
+	if err != nil {
+		log.Print(msg("Unable to register %s in DNS: %s\n", fqdn, err))
+	}
+}
+
+// deregisterSession removes the records of a session
+func deregisterSession(config *Config, owner string, name string) {
+	c := &config.DNS
+	if c.Zone == "" || owner == "" || name == "" {
+		return
+	}
+
+	fqdn := c.sessionFQDN(owner, name)
+	if err := c.nsupdate([]string{"update delete " + fqdn, "update delete _ssh._tcp." + fqdn}); err != nil {
+		log.Print(msg("Unable to remove %s from DNS: %s\n", fqdn, err))
+	}
+}
@@ -22,181 +22,321 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
-	"net/url"
 	"os"
 	"os/exec"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/fsouza/go-dockerclient"
 	"launchpad.net/goyaml"
 )
 
 type Config struct {
-	Endpoints []string `yaml:"endpoints,omitempty"`
-	Image     string   `yaml:"image,omitempty"`
-	User      string   `yaml:"user,omitempty"`
-	MaxAge    int      `yaml:"max_age,omitempty"`
+	Profile         `yaml:",inline"`
+	Profiles        map[string]Profile        `yaml:"profiles,omitempty"`
+	Endpoints       []string                  `yaml:"endpoints,omitempty"`
+	MaxAge          int                       `yaml:"max_age,omitempty"`
+	SSHConfig       bool                      `yaml:"ssh_config,omitempty"`
+	Warnings        bool                      `yaml:"resource_warnings,omitempty"`
+	Language        string                    `yaml:"language,omitempty"`
+	Messages        string                    `yaml:"messages,omitempty"`
+	Terse           bool                      `yaml:"terse,omitempty"`
+	ExpiryPrompt    bool                      `yaml:"expiry_prompt,omitempty"`
+	StateDir        string                    `yaml:"state_dir,omitempty"`
+	SMTP            SMTPConfig                `yaml:"smtp,omitempty"`
+	Notifiers       []NotifierConfig          `yaml:"notifiers,omitempty"`
+	Audit           AuditConfig               `yaml:"audit,omitempty"`
+	Tracing         TracingConfig             `yaml:"tracing,omitempty"`
+	TOTP            TOTPConfig                `yaml:"totp,omitempty"`
+	Discovery       DiscoveryConfig           `yaml:"discovery,omitempty"`
+	Affinity        bool                      `yaml:"affinity,omitempty"`
+	Regions         map[string][]string       `yaml:"regions,omitempty"`
+	EndpointOpts    map[string]EndpointConfig `yaml:"endpoint_options,omitempty"`
+	UserConfig      bool                      `yaml:"user_config,omitempty"`
+	Fleet           string                    `yaml:"fleet,omitempty"`
+	Update          UpdateConfig              `yaml:"update,omitempty"`
+	Faults          FaultConfig               `yaml:"faults,omitempty"`
+	Budget          BudgetConfig              `yaml:"budget,omitempty"`
+	DNS             DNSConfig                 `yaml:"dns,omitempty"`
+	SSHIdentity     SSHIdentityConfig         `yaml:"ssh_identity,omitempty"`
+	Identity        IdentityConfig            `yaml:"identity,omitempty"`
+	DefaultUser     string                    `yaml:"default_user,omitempty"`
+	UserEnv         string                    `yaml:"user_env,omitempty"`
+	Admins          []string                  `yaml:"admins,omitempty"`
+	AdminGroups     []string                  `yaml:"admin_groups,omitempty"`
+	Fleets          map[string]Config         `yaml:"fleets,omitempty"`
+	WarmPool        int                       `yaml:"warm_pool,omitempty"`
+	Draining        []string                  `yaml:"draining,omitempty"`
+	Placement       string                    `yaml:"placement,omitempty"`
+	UndeleteWindow  int                       `yaml:"undelete_window,omitempty"`
+	ScheduleRepeats int                       `yaml:"schedule_repeats,omitempty"`
+	PortableEnv     []string                  `yaml:"portable_env,omitempty"`
+	CleanupApproval CleanupApprovalConfig     `yaml:"cleanup_approval,omitempty"`
+	Policies        []PolicyConfig            `yaml:"policies,omitempty"`
+	Justification   JustificationConfig       `yaml:"justification,omitempty"`
+	SSHClient       SSHClientConfig           `yaml:"ssh_client,omitempty"`
+	SharedHistory   bool                      `yaml:"shared_history,omitempty"`
+
+	settings profileSettings
+}
+
+// stateDir is where dockersshell keeps state between cleanup runs
+func (c *Config) stateDir() string {
+	if c.StateDir == "" {
+		return "/var/lib/dockersshell"
+	}
+	return c.StateDir
+}
+
+// userSettings are the settings a user config may change when it is laid
+// over /etc/dockersshell.yaml. They only affect the user's own client;
+// admins, profiles, policies, auditing and the like stay as the bastion set
+// them.
+type userSettings struct {
+	Endpoints []string        `yaml:"endpoints,omitempty"`
+	Image     string          `yaml:"image,omitempty"`
+	User      string          `yaml:"user,omitempty"`
+	SSHClient SSHClientConfig `yaml:"ssh_client,omitempty"`
+	SSHConfig bool            `yaml:"ssh_config,omitempty"`
+	Language  string          `yaml:"language,omitempty"`
+	Terse     bool            `yaml:"terse,omitempty"`
+	Fleet     string          `yaml:"fleet,omitempty"`
+}
+
+// apply copies the settings the user config sets onto config
+func (s *userSettings) apply(config *Config) {
+	if len(s.Endpoints) != 0 {
+		config.Endpoints = s.Endpoints
+	}
+	if s.Image != "" {
+		config.Image = s.Image
+	}
+	if s.User != "" {
+		config.User = s.User
+	}
+	if s.SSHClient.Command != "" {
+		config.SSHClient = s.SSHClient
+	}
+	if s.Language != "" {
+		config.Language = s.Language
+	}
+	if s.Fleet != "" {
+		config.Fleet = s.Fleet
+	}
+	config.SSHConfig = config.SSHConfig || s.SSHConfig
+	config.Terse = config.Terse || s.Terse
 }
 
+// getconfig reads /etc/dockersshell.yaml and, when there is none, the user
+// config. A bastion has to opt in with user_config to have the user config
+// laid over its own, and then only the userSettings are taken from it. The
+// defaults are used when neither exists.
 func getconfig() *Config {
 	var config Config
 
 	defaults := []byte("endpoints: ['http://127.0.0.1:4243']\nimage: ssh\nuser: ubuntu\nmax_age: 86400")
 
 	text, err := ioutil.ReadFile("/etc/dockersshell.yaml")
-	if err != nil {
-		goyaml.Unmarshal([]byte(defaults), &config)
-	} else {
+	found := err == nil
+	if found {
 		goyaml.Unmarshal(text, &config)
+		goyaml.Unmarshal(text, &config.settings)
+	}
+
+	if text, err := ioutil.ReadFile(userConfigPath()); err == nil {
+		if !found {
+			goyaml.Unmarshal(text, &config)
+			goyaml.Unmarshal(text, &config.settings)
+			found = true
+		} else if config.UserConfig {
+			var settings userSettings
+			goyaml.Unmarshal(text, &settings)
+			settings.apply(&config)
+		}
+	}
+
+	if !found {
+		goyaml.Unmarshal([]byte(defaults), &config)
 	}
 
 	return &config
 }
 
-func connect(user string, host string, port string) {
-	cmd := exec.Command("ssh", "-q", "-p", port, "-l", user, host)
+// invoker is the user dockersshell runs for, as resolved at startup
+var invoker string
+
+// userEnv names the environment variable the user is exported in
+func (c *Config) userEnv() string {
+	if c.UserEnv == "" {
+		return "DSSHUSER"
+	}
+	return c.UserEnv
+}
+
+// requireUser fails when the user could not be determined, before anything
+// is looked up or created for them
+func requireUser(user string) {
+	if user == "" {
+		fail(ErrNoUser, msg("Unable to determine the user, set default_user to fall back to one"))
+	}
+}
+
+// connect runs ssh, or the configured ssh client, against the session on
+// endpoint and returns the exit status of the remote shell, or an error when
+// ssh could not connect. With strict set the host key must match the one
+// published to the dockersshell known hosts file.
+func connect(client *SSHClientConfig, endpoint string, user string, host string, port string, strict bool, env string) (int, error) {
+	options := client.options(endpoint, host, port, strict, env)
+	args, err := client.args(sshTarget{endpoint, user, host, port, options})
+	if err != nil {
+		return 0, fmt.Errorf("unable to expand ssh_client arguments: %s", err)
+	}
+	cmd := exec.Command(client.command(), args...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	if err != nil {
-		log.Fatal(fmt.Sprintf("Unable to initiate ssh connection: %s\n", err))
+
+	span := startSpan("connect")
+	err = cmd.Start()
+	span.End()
+	endLaunch()
+	if err == nil {
+		err = cmd.Wait()
+	}
+
+	// ssh exits with 255 for its own errors and with the status of the
+	// remote shell otherwise
+	if exit, ok := err.(*exec.ExitError); ok && exit.ExitCode() != 255 {
+		return exit.ExitCode(), nil
 	}
+	return 0, err
 }
 
-func wait(host string, port string) {
+func wait(endpoint string, host string, port string) {
+	if !sshReady(endpoint, host, port) {
+		fail(ErrWaitTimeout, msg("%s:%s never became available", host, port))
+	}
+}
+
+// sshReady waits up to 30 seconds for sshd to answer on host and port
+func sshReady(endpoint string, host string, port string) bool {
 	buf := make([]byte, 20)
+	address := net.JoinHostPort(host, port)
+	proxy := proxyFor(endpoint, address)
 	for i := 0; i < 60; i++ {
-		conn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", host, port))
+		conn, err := dialProxy(proxy, address, 5*time.Second)
 		if err == nil {
 			_, err := bufio.NewReader(conn).Read(buf)
 			if err == nil && strings.Contains(string(buf), "SSH") {
-				return
+				return true
 			}
 		}
 		time.Sleep(500 * time.Millisecond)
 	}
-	log.Fatal(fmt.Sprintf("%s:%s never became available", host, port))
+	return false
 }
 
 func main() {
-	var Endpoint string
 	var CleanUp bool
-	Smallest := 1024
-	user := os.Getenv("USER")
-	os.Setenv("DSSHUSER", user)
-	stamp := strconv.FormatInt(time.Now().Unix(), 10)
-	name := fmt.Sprintf("%s-%s", user, stamp)
+	var Terse bool
+	var Fleet string
+	var Harness string
+	var Again bool
+	var ProxyName string
+	var opts sessionOptions
 
-	flag.BoolVar(&CleanUp, "clean", false, "Clean up old containers")
+	flag.BoolVar(&CleanUp, "clean", false, "Clean up old containers (same as the clean command)")
+	flag.BoolVar(&opts.SSHConfig, "ssh-config", false, "Add a Host entry for the session to ~/.ssh/dockersshell_config while it is running")
+	flag.StringVar(&opts.Name, "name", "", "Human friendly name for the session")
+	flag.StringVar(&opts.Profile, "profile", "", "Profile to create the session from")
+	flag.StringVar(&opts.Region, "region", "", "Region to create the session in, instead of the closest")
+	flag.BoolVar(&Again, "again", false, "Repeat the profile, region and ssh config of your last session")
+	flag.StringVar(&opts.Reason, "reason", "", "Why you need the session, when a justification is required")
+	flag.StringVar(&opts.Ticket, "ticket", "", "Ticket the session is for, when a justification is required")
+	opts.Tags = tagFlags{}
+	flag.Var(opts.Tags, "tag", "Tag the session with KEY=VALUE, can be repeated")
+	flag.DurationVar(&opts.Timeout, "timeout", 0, "Give up and remove the session if it is not ready to connect within this long")
+	flag.BoolVar(&plain, "no-color", false, "Disable colored output")
+	flag.BoolVar(&plain, "plain", false, "Disable colored output (same as -no-color)")
+	flag.BoolVar(&Terse, "terse", false, "Only print short progress messages")
+	flag.StringVar(&Fleet, "fleet", "", "Fleet to use, instead of the configured default")
+	flag.BoolVar(&nonInteractive, "non-interactive", false, "Never prompt, failing with exit status 7 instead, and write errors as JSON")
+	flag.StringVar(&ProxyName, "proxy-command", "", "Act as an ssh ProxyCommand for the named session, creating it if needed")
+	flag.StringVar(&Harness, "test-harness", "", "Run the lifecycle tests against docker-in-docker on this docker endpoint")
+	flag.Usage = usage
 	flag.Parse()
+	if nonInteractive {
+		log.SetFlags(0)
+		log.SetOutput(jsonLog{})
+	}
 
 	config := getconfig()
-
-	listOptions := docker.ListContainersOptions{
-		All:    false,
-		Size:   false,
-		Limit:  -1,
-		Since:  "",
-		Before: "",
-	}
-	for _, endpoint := range config.Endpoints {
-		client, err := docker.NewClient(endpoint)
-		if err != nil {
-			continue
-		}
-
-		containers, err := client.ListContainers(listOptions)
-		if err != nil {
-			continue
-		}
-
-		if CleanUp {
-			for _, container := range containers {
-				if len(container.Names) != 1 {
-					continue
-				}
-				parts := strings.Split(container.Names[0], "-")
-				if len(parts) != 2 {
-					continue
-				}
-				created, err := strconv.ParseInt(parts[1], 10, 64)
-				if err == nil && config.MaxAge != 0 && time.Now().Unix()-created > int64(config.MaxAge) {
-					if client.StopContainer(container.ID, 0) != nil {
-						log.Fatal(fmt.Sprintf("Unable to stop container: %s\n", err))
-					}
-
-					remove := docker.RemoveContainerOptions{ID: container.ID, RemoveVolumes: false}
-					if client.RemoveContainer(remove) != nil {
-						log.Fatal(fmt.Sprintf("Unable to remove container: %s\n", err))
-					}
-				}
-			}
-		} else {
-			if len(containers) == 0 {
-				Endpoint = endpoint
-				break
-			} else if len(containers) < Smallest {
-				Endpoint = endpoint
-				Smallest = len(containers)
-			}
-		}
+	if err := config.useFleet(Fleet); err != nil {
+		log.Fatal(msg("Unable to use fleet: %s\n", err))
 	}
-
-	if CleanUp {
-		os.Exit(0)
+	loadMessages(config)
+	user, err := resolveUser(config, flag.NArg() == 0)
+	if err != nil {
+		log.Fatal(msg("Unable to identify the user: %s\n", err))
 	}
-
-	if Endpoint == "" {
-		log.Fatal("No acceptable endpoints found")
+	if user, assignedProfile, err = sshIdentity(config, user); err != nil {
+		log.Fatal(msg("Unable to identify the user: %s\n", err))
 	}
-
-	Url, err := url.Parse(Endpoint)
-	if err != nil {
-		log.Fatal(fmt.Sprintf("Unable to parse endpoint URL: %s\n", err))
-	} else if Url.Host == "" {
-		log.Fatal("No host found in endpoint")
+	if user == "" && config.DefaultUser != "" {
+		status(colorYellow, "Unable to determine the user, using %s", config.DefaultUser)
+		user = config.DefaultUser
 	}
-
-	hostPort := strings.SplitN(Url.Host, ":", 2)
-
-	client, err := docker.NewClient(Endpoint)
-	if err != nil {
-		log.Fatal(fmt.Sprintf("Unable to communicate: %s\n", err))
+	invoker = user
+	os.Setenv(config.userEnv(), user)
+	endpointConfigs = config.EndpointOpts
+	faults = config.Faults
+	if err := checkPlacement(config.Placement); err != nil {
+		log.Fatal(msg("Unable to place sessions: %s\n", err))
 	}
-
-	dockerConfig := docker.Config{Image: config.Image}
-	opts := docker.CreateContainerOptions{Name: name, Config: &dockerConfig}
-	container, err := client.CreateContainer(opts)
-	if err != nil {
-		log.Fatal(fmt.Sprintf("Unable to create container: %s\n", err))
+	placement = config.Placement
+	discoverEndpoints(config)
+	addRegionEndpoints(config)
+	stopTracing := initTracing(config)
+	if Terse {
+		terse = true
 	}
-
-	host := docker.HostConfig{PublishAllPorts: true}
-	if client.StartContainer(container.ID, &host) != nil {
-		log.Fatal(fmt.Sprintf("Unable to start container: %s\n", err))
+	if config.SSHConfig {
+		opts.SSHConfig = true
 	}
-
-	inspect, err := client.InspectContainer(container.ID)
-	if err != nil {
-		fmt.Printf("Unable to get port information for container: %s\n", err)
+	if Again {
+		opts.replay(flag.CommandLine)
 	}
-	port := inspect.NetworkSettings.Ports["22/tcp"][0].HostPort
 
-	wait(hostPort[0], port)
+	if CleanUp {
+		if !cleanup(config, nil) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	connect(config.User, hostPort[0], port)
+	if Harness != "" {
+		testHarness(config, Harness)
+		os.Exit(0)
+	}
 
-	if client.StopContainer(container.ID, 0) != nil {
-		log.Fatal(fmt.Sprintf("Unable to stop container: %s\n", err))
+	if ProxyName != "" {
+		opts.Name = ProxyName
+		proxySession(config, user, opts)
+		os.Exit(0)
 	}
 
-	remove := docker.RemoveContainerOptions{ID: container.ID, RemoveVolumes: false}
-	if client.RemoveContainer(remove) != nil {
-		log.Fatal(fmt.Sprintf("Unable to remove container: %s\n", err))
+	if flag.NArg() == 0 {
+		run(config, user, opts)
+		stopTracing()
+		os.Exit(0)
 	}
 
+	cmd := findCommand(flag.Arg(0))
+	if cmd == nil {
+		fmt.Fprint(os.Stderr, msg("Unknown command: %s\n\n", flag.Arg(0)))
+		usage()
+		os.Exit(2)
+	}
+	cmd.Run(config, user, flag.Args()[1:])
+	stopTracing()
 	os.Exit(0)
 }
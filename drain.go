@@ -0,0 +1,146 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func drainPath(config *Config) string {
+	return filepath.Join(config.stateDir(), "draining")
+}
+
+// drainingEndpoints returns the endpoints drained in the config or with the
+// drain command
+func drainingEndpoints(config *Config) []string {
+	endpoints := append([]string{}, config.Draining...)
+	text, err := ioutil.ReadFile(drainPath(config))
+	if err == nil {
+		endpoints = append(endpoints, strings.Fields(string(text))...)
+	}
+	return endpoints
+}
+
+func isDraining(config *Config, endpoint string) bool {
+	for _, draining := range drainingEndpoints(config) {
+		if draining == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func setDraining(config *Config, endpoint string, draining bool) error {
+	var endpoints []string
+	text, err := ioutil.ReadFile(drainPath(config))
+	if err == nil {
+		for _, e := range strings.Fields(string(text)) {
+			if e != endpoint {
+				endpoints = append(endpoints, e)
+			}
+		}
+	}
+	if draining {
+		endpoints = append(endpoints, endpoint)
+	}
+
+	if err := os.MkdirAll(config.stateDir(), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(drainPath(config), []byte(strings.Join(endpoints, "\n")+"\n"), 0644)
+}
+
+// endpointSessions returns every session on endpoint, whoever owns it
+func endpointSessions(endpoint string) (*docker.Client, []docker.APIContainers, error) {
+	client, err := dockerClient(endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	containers, err := managedContainers(client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sessions []docker.APIContainers
+	for _, container := range containers {
+		poolLabels(&container)
+		if container.Labels[labelOwner] != "" {
+			sessions = append(sessions, container)
+		}
+	}
+	return client, sessions, nil
+}
+
+func runDrain(config *Config, user string, args []string) {
+	fs := findCommand("drain").flags()
+	undo := fs.Bool("undo", false, "Stop draining the endpoint")
+	notice := fs.String("notify", "", "Message to send to every session on the endpoint")
+	move := fs.Bool("migrate", false, "Migrate the sessions on the endpoint elsewhere")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		for _, endpoint := range drainingEndpoints(config) {
+			fmt.Println(endpoint)
+		}
+		return
+	}
+
+	requireAdmin(config, user, "drain endpoints")
+	endpoint := fs.Arg(0)
+	if err := setDraining(config, endpoint, !*undo); err != nil {
+		log.Fatal(msg("Unable to update drain state: %s\n", err))
+	}
+	if *undo {
+		status(colorGreen, "%s is accepting new sessions", endpoint)
+		return
+	}
+	status(colorYellow, "%s is draining, no new sessions will be placed there", endpoint)
+
+	client, sessions, err := endpointSessions(endpoint)
+	if err != nil {
+		log.Fatal(msg("Unable to list sessions on %s: %s\n", endpoint, err))
+	}
+
+	fmt.Printf("%-30s %-20s %-20s\n", "NAME", "OWNER", "CREATED")
+	for _, container := range sessions {
+		created := time.Unix(container.Created, 0).Format("2006-01-02 15:04:05")
+		fmt.Printf("%-30s %-20s %-20s\n", container.Labels[labelName], container.Labels[labelOwner], created)
+		if *notice != "" {
+			warnSession(client, container.ID, *notice)
+		}
+		if *move {
+			target := migrationTarget(config, endpoint, container.Labels[labelOwner])
+			if _, err := migrate(config, endpoint, client, container.ID, target); err != nil {
+				log.Print(msg("Unable to migrate %s: %s\n", container.Labels[labelName], err))
+				continue
+			}
+			status(colorGreen, "Migrated %s to %s", container.Labels[labelName], target)
+		}
+	}
+
+	if *notice != "" {
+		notify(config, eventDrain, "%s is draining: %s", endpoint, *notice)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// EndpointConfig holds settings for reaching one endpoint's docker API
+type EndpointConfig struct {
+	Token   string            `yaml:"token,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Proxy   string            `yaml:"proxy,omitempty"`
+	Connect string            `yaml:"connect_address,omitempty"`
+	GPUs    []string          `yaml:"gpus,omitempty"`
+	Bind    string            `yaml:"bind_address,omitempty"`
+
+	Maintenance []TimeWindow `yaml:"maintenance,omitempty"`
+
+	HourlyCost float64 `yaml:"hourly_cost,omitempty"`
+}
+
+// connectHost returns the address sessions on endpoint are reached at over
+// SSH, which differs from the API host behind NAT or a load balancer
+func connectHost(endpoint string) string {
+	if address := endpointConfigs[endpoint].Connect; address != "" {
+		return address
+	}
+	return endpointHost(endpoint)
+}
+
+// bindPorts publishes the SSH port of a session on endpoint only on its
+// bind_address, if it has one, rather than on every interface of the host
+func bindPorts(host *docker.HostConfig, endpoint string) {
+	host.PublishAllPorts = true
+	host.PortBindings = nil
+	if address := endpointConfigs[endpoint].Bind; address != "" {
+		host.PublishAllPorts = false
+		host.PortBindings = map[docker.Port][]docker.PortBinding{
+			"22/tcp": {{HostIP: address}},
+		}
+	}
+}
+
+// endpointConfigs is set from the config at startup, so that every docker
+// client is created with its endpoint's settings
+var endpointConfigs map[string]EndpointConfig
+
+// headerTransport adds fixed headers to every request, for endpoints behind
+// an authenticating proxy
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// dockerClient returns a client for endpoint with its configured settings
+func dockerClient(endpoint string) (*docker.Client, error) {
+	client, err := docker.NewClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Injected delays wrap the finished transport, so they apply whatever
+	// the endpoint settings are
+	defer func() {
+		client.HTTPClient.Transport = injectDelay(client.HTTPClient.Transport)
+	}()
+
+	settings, ok := endpointConfigs[endpoint]
+	if !ok {
+		return client, nil
+	}
+
+	headers := map[string]string{}
+	for name, value := range settings.Headers {
+		headers[name] = value
+	}
+	if settings.Token != "" {
+		headers["Authorization"] = "Bearer " + settings.Token
+	}
+
+	if settings.Proxy != "" {
+		proxy, err := url.Parse(settings.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		if transport, ok := client.HTTPClient.Transport.(*http.Transport); ok {
+			transport = transport.Clone()
+			transport.Proxy = http.ProxyURL(proxy)
+			client.HTTPClient.Transport = transport
+		}
+	}
+
+	if len(headers) != 0 {
+		base := client.HTTPClient.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.HTTPClient.Transport = &headerTransport{base, headers}
+	}
+	return client, nil
+}
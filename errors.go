@@ -0,0 +1,96 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Failures wrappers may want to tell apart, each with its own exit status.
+// Anything else exits with 1.
+var (
+	ErrNoEndpoints   = errors.New("no acceptable endpoints")
+	ErrCreateFailed  = errors.New("container could not be created")
+	ErrWaitTimeout   = errors.New("sshd never became available")
+	ErrSSHFailed     = errors.New("ssh connection failed")
+	ErrPrompt        = errors.New("a prompt is needed")
+	ErrNoUser        = errors.New("the user could not be determined")
+	ErrLaunchTimeout = errors.New("the session was not ready in time")
+)
+
+var exitCodes = map[error]int{
+	ErrNoEndpoints:   3,
+	ErrCreateFailed:  4,
+	ErrWaitTimeout:   5,
+	ErrSSHFailed:     6,
+	ErrPrompt:        7,
+	ErrNoUser:        8,
+	ErrLaunchTimeout: 9,
+}
+
+// nonInteractive is set by -non-interactive, for use from automation:
+// anything that would prompt fails with ErrPrompt instead, and errors are
+// written to stderr as JSON
+var nonInteractive bool
+
+// jsonLog writes every log message as a JSON object on its own line
+type jsonLog struct{}
+
+func (jsonLog) Write(p []byte) (int, error) {
+	text, _ := json.Marshal(map[string]string{"message": strings.TrimSpace(string(p))})
+	fmt.Fprintln(os.Stderr, string(text))
+	return len(p), nil
+}
+
+// noPrompt fails with ErrPrompt when prompting is not allowed
+func noPrompt(what string) {
+	if nonInteractive {
+		fail(ErrPrompt, msg("%s needs a prompt, which -non-interactive disallows", what))
+	}
+}
+
+// exitCode returns the exit status for err
+func exitCode(err error) int {
+	for target, code := range exitCodes {
+		if errors.Is(err, target) {
+			return code
+		}
+	}
+	return 1
+}
+
+// fail is log.Fatal for the failures in exitCodes. Without prompts the JSON
+// error also carries the error and exit status. The spans of a launch cut
+// short are exported first.
+func fail(err error, message string) {
+	endTracing(err)
+	if nonInteractive {
+		text, _ := json.Marshal(map[string]interface{}{
+			"message": strings.TrimSpace(message),
+			"error":   err.Error(),
+			"status":  exitCode(err),
+		})
+		fmt.Fprintln(os.Stderr, string(text))
+	} else {
+		log.Print(message)
+	}
+	os.Exit(exitCode(err))
+}
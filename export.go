@@ -0,0 +1,220 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"launchpad.net/goyaml"
+)
+
+// sessionDefinition is a portable description of a session, written by
+// export and recreated by import. The image is pinned by digest when the
+// endpoint knows it, so the session can be reproduced later or elsewhere.
+type sessionDefinition struct {
+	Name    string            `yaml:"name" json:"name"`
+	Profile string            `yaml:"profile,omitempty" json:"profile,omitempty"`
+	Image   string            `yaml:"image,omitempty" json:"image,omitempty"`
+	Env     []string          `yaml:"env,omitempty" json:"env,omitempty"`
+	Tags    map[string]string `yaml:"tags,omitempty" json:"tags,omitempty"`
+}
+
+// defaultPortableEnv are the environment variables sessions may carry
+// between export and import unless portable_env says otherwise. None of them
+// changes what the root entrypoint or sshd runs.
+var defaultPortableEnv = []string{"LANG", "LANGUAGE", "LC_*", "TZ", "EDITOR", "VISUAL", "PAGER"}
+
+// portableEnv reports whether an environment variable of a session belongs
+// in its definition: named in portable_env, and neither set by the image nor
+// by the profile, which sets it again anyway and may hold secrets in it
+func portableEnv(config *Config, profile *Profile, env string, image []string) bool {
+	name := strings.SplitN(env, "=", 2)[0]
+	allowed := config.PortableEnv
+	if len(allowed) == 0 {
+		allowed = defaultPortableEnv
+	}
+	portable := false
+	for _, pattern := range allowed {
+		if ok, _ := path.Match(pattern, name); ok {
+			portable = true
+		}
+	}
+	if !portable || name == config.userEnv() {
+		return false
+	}
+
+	for _, e := range image {
+		if e == env {
+			return false
+		}
+	}
+	var fragment struct{ Env []string }
+	if profile.DockerConfig != "" && json.Unmarshal([]byte(profile.DockerConfig), &fragment) == nil {
+		for _, e := range fragment.Env {
+			if strings.SplitN(e, "=", 2)[0] == name {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// imageRepository returns an image reference without its tag or digest
+func imageRepository(image string) string {
+	image = strings.SplitN(image, "@", 2)[0]
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image
+}
+
+func describeSession(config *Config, client *docker.Client, id string) (*sessionDefinition, error) {
+	container, err := client.InspectContainer(id)
+	if err != nil {
+		return nil, err
+	}
+	labels := container.Config.Labels
+	definition := &sessionDefinition{
+		Name:    labels[labelName],
+		Profile: labels[labelProfile],
+		Image:   labels[labelImage],
+	}
+
+	var imageEnv []string
+	if image, err := client.InspectImage(container.Image); err == nil {
+		if image.Config != nil {
+			imageEnv = image.Config.Env
+		}
+		for _, digest := range image.RepoDigests {
+			if imageRepository(digest) == imageRepository(definition.Image) {
+				definition.Image = digest
+			}
+		}
+	}
+	profile := sessionProfile(config, labels)
+	for _, env := range container.Config.Env {
+		if portableEnv(config, profile, env, imageEnv) {
+			definition.Env = append(definition.Env, env)
+		}
+	}
+	if tags := sessionTags(labels); tags != "" {
+		definition.Tags = map[string]string{}
+		for _, tag := range strings.Split(tags, ",") {
+			parts := strings.SplitN(tag, "=", 2)
+			definition.Tags[parts[0]] = parts[1]
+		}
+	}
+	return definition, nil
+}
+
+// runExport writes the definition of a session as YAML, or JSON with -json
+func runExport(config *Config, user string, args []string) {
+	fs := findCommand("export").flags()
+	asJSON := fs.Bool("json", false, "Write JSON instead of YAML")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+
+	s := findSession(config.Endpoints, user, name)
+	if s == nil {
+		log.Fatal(msg("No session named %s", name))
+	}
+	definition, err := describeSession(config, s.Client, s.Container.ID)
+	if err != nil {
+		log.Fatal(msg("Unable to export %s: %s\n", name, err))
+	}
+
+	var text []byte
+	if *asJSON {
+		text, err = json.MarshalIndent(definition, "", "  ")
+		text = append(text, '\n')
+	} else {
+		text, err = goyaml.Marshal(definition)
+	}
+	if err != nil {
+		log.Fatal(msg("Unable to export %s: %s\n", name, err))
+	}
+	os.Stdout.Write(text)
+}
+
+// importSession creates a session from a definition written by export, read
+// from path or stdin for -. The pinned image must be of the repository of
+// the profile's image, as profiles decide what users may run.
+func importSession(config *Config, user string, path string, opts sessionOptions) {
+	var text []byte
+	var err error
+	if path == "-" {
+		text, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		text, err = ioutil.ReadFile(path)
+	}
+	var definition sessionDefinition
+	if err == nil {
+		// JSON is YAML as well
+		err = goyaml.Unmarshal(text, &definition)
+	}
+	if err != nil {
+		log.Fatal(msg("Unable to read session definition: %s\n", err))
+	}
+
+	if opts.Name == "" {
+		opts.Name = definition.Name
+	}
+	opts.Profile = definition.Profile
+	opts.Tags = tagFlags(definition.Tags)
+	profile, err := config.profile(opts.Profile)
+	if err != nil {
+		log.Fatal(msg("Unable to use profile: %s\n", err))
+	}
+	if definition.Image != "" {
+		repository := imageRepository(definition.Image)
+		if repository != imageRepository(profile.Image) && repository != imageRepository(profile.CanaryImage) {
+			log.Fatal(msg("Unable to use image %s: profile %q uses %s", definition.Image, opts.Profile, profile.Image))
+		}
+		opts.Image = definition.Image
+	}
+	for _, env := range definition.Env {
+		if portableEnv(config, profile, env, nil) {
+			opts.Env = append(opts.Env, env)
+		}
+	}
+
+	if opts.Name != "" {
+		if s := findSession(config.Endpoints, user, opts.Name); s != nil {
+			log.Fatal(msg("Session %s already exists on %s", opts.Name, s.Endpoint))
+		}
+	}
+	provision(config, user, opts)
+}
+
+// pullMissing pulls image onto an endpoint that does not have it yet, for
+// sessions pinned to an image digest
+func pullMissing(client *docker.Client, image string) error {
+	if _, err := client.InspectImage(image); err == nil {
+		return nil
+	}
+	status(colorBlue, "Pulling %s", image)
+	return client.PullImage(docker.PullImageOptions{Repository: image}, docker.AuthConfiguration{})
+}
@@ -0,0 +1,67 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// FaultConfig injects faults for exercising failure handling in staging.
+// It is deliberately left out of the documentation.
+type FaultConfig struct {
+	Delay             string `yaml:"delay,omitempty"`
+	FailCreatePercent int    `yaml:"fail_create_percent,omitempty"`
+}
+
+// faults is set from the config at startup
+var faults FaultConfig
+
+// delayTransport holds every docker API request back by a fixed delay
+type delayTransport struct {
+	base  http.RoundTripper
+	delay time.Duration
+}
+
+func (t *delayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	time.Sleep(t.delay)
+	return t.base.RoundTrip(req)
+}
+
+// injectDelay wraps transport with the configured delay, if any
+func injectDelay(transport http.RoundTripper) http.RoundTripper {
+	if faults.Delay == "" {
+		return transport
+	}
+	delay, err := time.ParseDuration(faults.Delay)
+	if err != nil {
+		return transport
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &delayTransport{transport, delay}
+}
+
+// injectCreateFault fails the configured share of container creates
+func injectCreateFault() error {
+	if faults.FailCreatePercent != 0 && rand.Intn(100) < faults.FailCreatePercent {
+		return fmt.Errorf("injected create failure")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"launchpad.net/goyaml"
+)
+
+// useFleet lays the named fleet's settings over the rest of the config. An
+// empty name selects the configured default fleet, if any.
+func (c *Config) useFleet(name string) error {
+	if name == "" {
+		name = c.Fleet
+	}
+	if name == "" {
+		return nil
+	}
+
+	fleet, ok := c.Fleets[name]
+	if !ok {
+		return fmt.Errorf("no fleet named %s", name)
+	}
+
+	// As with profiles, only the settings the fleet sets survive
+	// marshalling, so unmarshalling them over the config merges the two
+	text, err := goyaml.Marshal(fleet)
+	if err != nil {
+		return err
+	}
+	if err := goyaml.Unmarshal(text, c); err != nil {
+		return err
+	}
+	for profile, settings := range c.settings.Fleets[name].Profiles {
+		if c.settings.Profiles == nil {
+			c.settings.Profiles = map[string]map[string]interface{}{}
+		}
+		c.settings.Profiles[profile] = settings
+	}
+	c.Fleet = name
+	c.Fleets = nil
+	return nil
+}
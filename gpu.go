@@ -0,0 +1,75 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// labelGPUs records the GPUs or MIG slices assigned to a session, which is
+// how their use is tracked across endpoints
+const labelGPUs = "dockersshell.gpus"
+
+// hasGPUs reports whether any endpoint has GPUs configured
+func hasGPUs() bool {
+	for _, settings := range endpointConfigs {
+		if len(settings.GPUs) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// freeGPUs returns the GPUs of endpoint not assigned to any of its running
+// containers
+func freeGPUs(endpoint string, containers []docker.APIContainers) []string {
+	used := map[string]bool{}
+	for _, container := range containers {
+		for _, id := range strings.Split(container.Labels[labelGPUs], ",") {
+			used[id] = true
+		}
+	}
+
+	var free []string
+	for _, id := range endpointConfigs[endpoint].GPUs {
+		if !used[id] {
+			free = append(free, id)
+		}
+	}
+	return free
+}
+
+// requireGPUs rules out the endpoints without count free GPUs
+func requireGPUs(states []endpointState, count int) {
+	for i := range states {
+		if len(states[i].FreeGPUs) < count {
+			states[i].Down = true
+		}
+	}
+}
+
+// assignGPUs requests specific GPUs for a session, so that MIG slices are
+// pinned and tracked rather than left to the runtime
+func assignGPUs(dockerConfig *docker.Config, host *docker.HostConfig, ids []string) {
+	dockerConfig.Labels[labelGPUs] = strings.Join(ids, ",")
+	host.DeviceRequests = append(host.DeviceRequests, docker.DeviceRequest{
+		Driver:       "nvidia",
+		DeviceIDs:    ids,
+		Capabilities: [][]string{{"gpu"}},
+	})
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// harnessName is the docker-in-docker container of the test harness. A
+// fixed name means a run that died half way is cleaned up by the next one.
+const harnessName = "dockersshell-harness"
+
+// startDind starts a disposable docker-in-docker endpoint on the docker
+// daemon of client and returns its API endpoint, reached over the bridge
+// network
+func startDind(client *docker.Client) (string, error) {
+	client.RemoveContainer(docker.RemoveContainerOptions{ID: harnessName, Force: true, RemoveVolumes: true})
+
+	repository, tag := docker.ParseRepositoryTag("docker:dind")
+	if err := client.PullImage(docker.PullImageOptions{Repository: repository, Tag: tag}, docker.AuthConfiguration{}); err != nil {
+		return "", err
+	}
+
+	dockerConfig := docker.Config{Image: "docker:dind", Env: []string{"DOCKER_TLS_CERTDIR="}}
+	host := docker.HostConfig{Privileged: true}
+	container, err := client.CreateContainer(docker.CreateContainerOptions{Name: harnessName, Config: &dockerConfig, HostConfig: &host})
+	if err != nil {
+		return "", err
+	}
+	if err := client.StartContainer(container.ID, &host); err != nil {
+		return "", err
+	}
+
+	inspect, err := client.InspectContainer(container.ID)
+	if err != nil {
+		return "", err
+	}
+	endpoint := fmt.Sprintf("http://%s:2375", inspect.NetworkSettings.IPAddress)
+
+	dind, err := docker.NewClient(endpoint)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < 60; i++ {
+		if err = dind.Ping(); err == nil {
+			return endpoint, nil
+		}
+		time.Sleep(time.Second)
+	}
+	return "", err
+}
+
+// testHarness runs the session lifecycle non-interactively against a
+// disposable docker-in-docker endpoint on the docker daemon at host, and
+// exits non-zero if any step does not behave as expected
+func testHarness(config *Config, host string) {
+	client, err := docker.NewClient(host)
+	if err != nil {
+		log.Fatal(msg("Unable to communicate: %s\n", err))
+	}
+
+	status(colorBlue, "Starting docker-in-docker on %s", host)
+	endpoint, err := startDind(client)
+	if err != nil {
+		log.Fatal(msg("Unable to start docker-in-docker: %s\n", err))
+	}
+
+	// Only what the lifecycle needs, so the harness never notifies, audits
+	// or traces into production systems
+	harness := &Config{Endpoints: []string{endpoint}, MaxAge: 1}
+	harness.Image = config.Image
+	harness.User = config.User
+
+	failed := 0
+	check := func(step string, ok bool) {
+		if ok {
+			status(colorGreen, "PASS %s", step)
+		} else {
+			status(colorYellow, "FAIL %s", step)
+			failed++
+		}
+	}
+
+	status(colorBlue, "Pulling %s into %s", harness.Image, endpoint)
+	pullImages(harness)
+
+	user := "harness"
+	endpointUsed, sessionClient, container := createSession(harness, user, sessionOptions{Name: "lifecycle"})
+	journalEnd(dockerName(container))
+	check("create places the session on the endpoint", endpointUsed == endpoint)
+
+	address, port := sessionAddress(endpointUsed, sessionClient, container.ID)
+	// wait exits when sshd never answers
+	wait(endpointUsed, address, port)
+	check("sshd answers on the published port", true)
+
+	keys, err := readHostKeys(sessionClient, container.ID)
+	check("host keys can be read", err == nil && len(keys) > 0)
+
+	s := findSession(harness.Endpoints, user, "lifecycle")
+	check("the session is found by name", s != nil && s.Container.ID == container.ID)
+
+	destroySession(harness, endpointUsed, sessionClient, container.ID, "lifecycle", -1)
+	check("the session is removed", findSession(harness.Endpoints, user, "lifecycle") == nil)
+
+	_, _, container = createSession(harness, user, sessionOptions{})
+	journalEnd(dockerName(container))
+	name := container.Config.Labels[labelName]
+	time.Sleep(2 * time.Second)
+	cleanup(harness, nil)
+	check("cleanup removes sessions older than max_age", findSession(harness.Endpoints, user, name) == nil)
+
+	if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: harnessName, Force: true, RemoveVolumes: true}); err != nil {
+		log.Print(msg("Unable to remove docker-in-docker: %s\n", err))
+	}
+
+	if failed != 0 {
+		status(colorYellow, "%d steps failed", failed)
+		os.Exit(1)
+	}
+	status(colorGreen, "All steps passed")
+}
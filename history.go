@@ -0,0 +1,218 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyEntry records a session once it is gone. ExitCode is the exit status
+// of the owner's shell, or -1 when nobody was connected as it was removed.
+type historyEntry struct {
+	Owner    string `json:"owner"`
+	Name     string `json:"name"`
+	Profile  string `json:"profile,omitempty"`
+	Endpoint string `json:"endpoint"`
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	ExitCode int    `json:"exit_code"`
+}
+
+func historyPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".dockersshell", "history.jsonl")
+}
+
+// sharedHistoryDir holds one history file per user when shared_history is
+// set. Like the schedule directory it is one of the userDirs set up by root.
+func sharedHistoryDir(config *Config) string {
+	return filepath.Join(config.stateDir(), "history")
+}
+
+// rootHistoryPath is where root records the sessions it removes for their
+// owners, as it never writes into the shared history directory
+func rootHistoryPath(config *Config) string {
+	return filepath.Join(config.stateDir(), "history.jsonl")
+}
+
+func appendHistory(f *os.File, entry historyEntry) error {
+	defer f.Close()
+	text, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(text, '\n'))
+	return err
+}
+
+// recordHistory appends a finished session to its owner's history, and to
+// the shared store when one is configured. Sessions removed by root, such as
+// by cleanup, only reach the shared store.
+func recordHistory(config *Config, entry historyEntry) {
+	if entry.Owner == "" {
+		return
+	}
+	if entry.Owner == invoker {
+		err := os.MkdirAll(filepath.Dir(historyPath()), 0700)
+		var f *os.File
+		if err == nil {
+			f, err = os.OpenFile(historyPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		}
+		if err == nil {
+			err = appendHistory(f, entry)
+		}
+		if err != nil {
+			log.Print(msg("Unable to record history: %s\n", err))
+		}
+	}
+	if !config.SharedHistory {
+		return
+	}
+
+	var f *os.File
+	var err error
+	switch {
+	case os.Geteuid() == 0:
+		f, err = os.OpenFile(rootHistoryPath(config), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	case entry.Owner == invoker:
+		dir := sharedHistoryDir(config)
+		if err = checkUserDir(dir); err == nil {
+			f, err = openUserFile(filepath.Join(dir, entry.Owner+".jsonl"), entry.Owner, os.O_WRONLY|os.O_APPEND|os.O_CREATE)
+		}
+	default:
+		// Only the owner and root can write to the owner's history
+		return
+	}
+	if err == nil {
+		err = appendHistory(f, entry)
+	}
+	if err != nil {
+		log.Print(msg("Unable to record shared history: %s\n", err))
+	}
+}
+
+// readHistory returns the entries of a history file. Files in the shared
+// history directory are only trusted for the user owning them, as anyone can
+// write to it; pass their owner to check.
+func readHistory(path string, owner string) ([]historyEntry, error) {
+	var f *os.File
+	var err error
+	if owner != "" {
+		f, err = openUserFile(path, owner, os.O_RDONLY)
+	} else {
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry historyEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+		if owner != "" && entry.Owner != owner {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// runHistory lists finished sessions, newest last. Admins can read the
+// shared store for another user or for everyone.
+func runHistory(config *Config, user string, args []string) {
+	fs := findCommand("history").flags()
+	profile := fs.String("profile", "", "Only show sessions created from this profile")
+	endpoint := fs.String("endpoint", "", "Only show sessions on this endpoint")
+	since := fs.Duration("since", 0, "Only show sessions that ended within this long")
+	owner := fs.String("user", user, "Show the history of another user, for admins")
+	all := fs.Bool("all", false, "Show the history of every user, for admins")
+	fs.Parse(args)
+
+	if *owner != user || *all {
+		requireAdmin(config, user, "read other users' history")
+		if !config.SharedHistory {
+			log.Fatal(msg("Reading other users' history requires shared_history"))
+		}
+	}
+
+	// Files in the shared directory are read for their owner, and root's
+	// file for whoever the history is shown for
+	type source struct{ path, owner string }
+	var sources []source
+	switch {
+	case *all:
+		files, err := ioutil.ReadDir(sharedHistoryDir(config))
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatal(msg("Unable to read history: %s\n", err))
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file.Name(), ".jsonl") {
+				sources = append(sources, source{filepath.Join(sharedHistoryDir(config), file.Name()), strings.TrimSuffix(file.Name(), ".jsonl")})
+			}
+		}
+		sources = append(sources, source{rootHistoryPath(config), ""})
+	case *owner != user:
+		sources = []source{{filepath.Join(sharedHistoryDir(config), *owner+".jsonl"), *owner}, {rootHistoryPath(config), ""}}
+	default:
+		sources = []source{{historyPath(), ""}}
+	}
+
+	fmt.Printf("%-15s %-30s %-15s %-30s %-20s %-10s %s\n", "OWNER", "NAME", "PROFILE", "ENDPOINT", "STARTED", "DURATION", "EXIT")
+	for _, source := range sources {
+		entries, err := readHistory(source.path, source.owner)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Print(msg("Unable to read history: %s\n", err))
+			}
+			continue
+		}
+		for _, entry := range entries {
+			if !*all && entry.Owner != *owner {
+				continue
+			}
+			if *profile != "" && entry.Profile != *profile {
+				continue
+			}
+			if *endpoint != "" && entry.Endpoint != *endpoint {
+				continue
+			}
+			if *since != 0 && time.Since(time.Unix(entry.End, 0)) > *since {
+				continue
+			}
+
+			started := time.Unix(entry.Start, 0).Format("2006-01-02 15:04:05")
+			duration := (time.Duration(entry.End-entry.Start) * time.Second).String()
+			exit := "-"
+			if entry.ExitCode >= 0 {
+				exit = strconv.Itoa(entry.ExitCode)
+			}
+			fmt.Printf("%-15s %-30s %-15s %-30s %-20s %-10s %s\n", entry.Owner, entry.Name, entry.Profile, entry.Endpoint, started, duration, exit)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func knownHostsPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "dockersshell_known_hosts")
+}
+
+// readHostKeys returns the public host keys of the sshd in the container
+func readHostKeys(client *docker.Client, id string) ([]string, error) {
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		Container:    id,
+		User:         "root",
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{"sh", "-c", "cat /etc/ssh/ssh_host_*_key.pub"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout bytes.Buffer
+	opts := docker.StartExecOptions{OutputStream: &stdout, ErrorStream: ioutil.Discard}
+	if err := client.StartExec(exec.ID, opts); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			keys = append(keys, fields[0]+" "+fields[1])
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no host keys found in /etc/ssh")
+	}
+	return keys, nil
+}
+
+// fingerprint formats a public key the way ssh-keygen -l does
+func fingerprint(key string) string {
+	fields := strings.Fields(key)
+	hash, err := keyHash(key)
+	if err != nil {
+		return fields[0] + " (invalid key)"
+	}
+	return fmt.Sprintf("%s (%s)", hash, fields[0])
+}
+
+// keyHash returns the SHA256 fingerprint of a public key
+func keyHash(key string) (string, error) {
+	blob, err := base64.StdEncoding.DecodeString(strings.Fields(key)[1])
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+// knownHostKeys returns the keys recorded for host:port
+func knownHostKeys(host string, port string) []string {
+	text, _ := ioutil.ReadFile(knownHostsPath())
+	pattern := knownHostsPattern(host, port) + " "
+	var keys []string
+	for _, line := range strings.Split(string(text), "\n") {
+		if strings.HasPrefix(line, pattern) {
+			keys = append(keys, strings.TrimPrefix(line, pattern))
+		}
+	}
+	return keys
+}
+
+// knownHostsPattern is how ssh names host:port in known hosts files. Without
+// a port it is the bare host, as ssh writes hosts on port 22.
+func knownHostsPattern(host string, port string) string {
+	if port == "" {
+		return host
+	}
+	return fmt.Sprintf("[%s]:%s", host, port)
+}
+
+// recordHostKeys replaces the known hosts entries for host:port with keys.
+// Ports are reused by later sessions, so stale entries have to go.
+func recordHostKeys(host string, port string, keys []string) error {
+	path := knownHostsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	text, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	pattern := knownHostsPattern(host, port)
+	var lines []string
+	for _, line := range strings.Split(string(text), "\n") {
+		if line != "" && !strings.HasPrefix(line, pattern+" ") {
+			lines = append(lines, line)
+		}
+	}
+	for _, key := range keys {
+		lines = append(lines, pattern+" "+key)
+	}
+
+	return ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// forgetHostKeys removes the known hosts entries for host:port once the
+// session using it is gone
+func forgetHostKeys(host string, port string) error {
+	if _, err := os.Stat(knownHostsPath()); os.IsNotExist(err) {
+		return nil
+	}
+	return recordHostKeys(host, port, nil)
+}
+
+// publishHostKeys reads the session's host keys, prints their fingerprints
+// and records them for strict host key checking. It reports whether the
+// keys were recorded.
+func publishHostKeys(client *docker.Client, id string, host string, port string) bool {
+	keys, err := readHostKeys(client, id)
+	if err == nil {
+		err = recordHostKeys(host, port, keys)
+	}
+	if err != nil {
+		log.Print(msg("Unable to publish host keys, host key will not be verified: %s\n", err))
+		return false
+	}
+
+	for _, key := range keys {
+		status(colorBlue, "Host key %s", fingerprint(key))
+	}
+	return true
+}
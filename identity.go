@@ -0,0 +1,427 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IdentityConfig picks how the user is identified. Providers are tried in
+// order until one answers; login applies instead when dockersshell runs as a
+// login shell, without a command.
+type IdentityConfig struct {
+	Providers []string   `yaml:"providers,omitempty"`
+	Login     []string   `yaml:"login,omitempty"`
+	OIDC      OIDCConfig `yaml:"oidc,omitempty"`
+}
+
+// OIDCConfig verifies an OIDC ID token passed in the environment and takes
+// the user from one of its claims
+type OIDCConfig struct {
+	TokenEnv string `yaml:"token_env,omitempty"`
+	JWKS     string `yaml:"jwks_url,omitempty"`
+	Issuer   string `yaml:"issuer,omitempty"`
+	Audience string `yaml:"audience,omitempty"`
+	Claim    string `yaml:"claim,omitempty"`
+}
+
+// identityProviders resolve who the user is. env trusts $USER, uid asks the
+// system for the real user ID, sudo prefers $SUDO_USER when run as root
+// through sudo, pam reads $PAM_USER as set by pam_exec, ssh takes the first
+// principal of the user's SSH certificate and oidc a claim of an ID token.
+var identityProviders = map[string]func(config *Config) (string, error){
+	"env": func(config *Config) (string, error) {
+		return os.Getenv("USER"), nil
+	},
+	"uid": func(config *Config) (string, error) {
+		return uidUser()
+	},
+	"sudo": func(config *Config) (string, error) {
+		if os.Getuid() == 0 && os.Getenv("SUDO_USER") != "" {
+			return os.Getenv("SUDO_USER"), nil
+		}
+		return uidUser()
+	},
+	"pam": func(config *Config) (string, error) {
+		return os.Getenv("PAM_USER"), nil
+	},
+	"ssh": func(config *Config) (string, error) {
+		principals, err := certificatePrincipals()
+		if err != nil {
+			return "", err
+		}
+		for _, principal := range principals {
+			if !strings.HasPrefix(principal, "profile:") {
+				return principal, nil
+			}
+		}
+		return "", nil
+	},
+	"oidc": func(config *Config) (string, error) {
+		return oidcUser(&config.Identity.OIDC)
+	},
+}
+
+// uidUser returns the name of the real user ID, which setuid wrappers and
+// the environment cannot change
+func uidUser() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
+
+// resolveUser returns the user according to the identity providers for the
+// mode, login or not
+func resolveUser(config *Config, login bool) (string, error) {
+	providers := config.Identity.Providers
+	if login && len(config.Identity.Login) != 0 {
+		providers = config.Identity.Login
+	}
+	if len(providers) == 0 {
+		providers = []string{"env"}
+	}
+
+	var errs []string
+	for _, name := range providers {
+		provider, ok := identityProviders[name]
+		if !ok {
+			return "", fmt.Errorf("unknown identity provider %s", name)
+		}
+		user, err := provider(config)
+		if err != nil {
+			errs = append(errs, name+": "+err.Error())
+			continue
+		}
+		if user != "" {
+			return user, nil
+		}
+	}
+	if len(errs) == 0 {
+		return "", nil
+	}
+	return "", fmt.Errorf("%s", strings.Join(errs, ", "))
+}
+
+// oidcUser verifies the RS256 signature, issuer, audience and expiry of the
+// ID token in the environment and returns its user claim
+func oidcUser(c *OIDCConfig) (string, error) {
+	env := c.TokenEnv
+	if env == "" {
+		env = "DSSHELL_TOKEN"
+	}
+	token := os.Getenv(env)
+	if token == "" {
+		return "", nil
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "RS256" {
+		return "", fmt.Errorf("unsupported token algorithm %s", header.Alg)
+	}
+
+	key, err := jwksKey(c.JWKS, header.Kid)
+	if err != nil {
+		return "", err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return "", fmt.Errorf("invalid token signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if exp, ok := claims["exp"].(float64); !ok || time.Now().Unix() > int64(exp) {
+		return "", fmt.Errorf("token expired")
+	}
+	if c.Issuer != "" && claims["iss"] != c.Issuer {
+		return "", fmt.Errorf("token issued by %v", claims["iss"])
+	}
+	if c.Audience != "" && !hasAudience(claims["aud"], c.Audience) {
+		return "", fmt.Errorf("token not meant for %s", c.Audience)
+	}
+
+	claim := c.Claim
+	if claim == "" {
+		claim = "preferred_username"
+	}
+	user, _ := claims[claim].(string)
+	return user, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	text, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(text, v)
+}
+
+// hasAudience checks the aud claim, a string or a list of them
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwksKey fetches the RSA key with the given key ID from a JWKS URL
+func jwksKey(url string, kid string) (*rsa.PublicKey, error) {
+	if url == "" {
+		return nil, fmt.Errorf("no jwks_url configured")
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+	for _, key := range jwks.Keys {
+		if key.Kid != kid || key.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return nil, fmt.Errorf("no key %s in %s", kid, url)
+}
+
+// SSHIdentityConfig takes the user, and optionally the profile, from how
+// they authenticated to sshd instead of from $USER
+type SSHIdentityConfig struct {
+	Certificates   bool   `yaml:"certificates,omitempty"`
+	Environment    bool   `yaml:"environment,omitempty"`
+	AuthorizedKeys string `yaml:"authorized_keys,omitempty"`
+}
+
+// authorizedKeys returns the AuthorizedKeysFile of sshd, with %h and %u
+// expanded as sshd does for the user dockersshell runs as
+func (c *SSHIdentityConfig) authorizedKeys() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	path := c.AuthorizedKeys
+	if path == "" {
+		path = ".ssh/authorized_keys"
+	}
+	path = strings.NewReplacer("%%", "%", "%h", u.HomeDir, "%u", u.Username).Replace(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(u.HomeDir, path)
+	}
+	return path, nil
+}
+
+// rootOnly fails unless path and every directory above it are owned by root
+// and writable by nobody else, so that no user can change what it says
+func rootOnly(path string) error {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	for {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if uid, ok := fileOwner(info); !ok || uid != "0" || info.Mode().Perm()&022 != 0 {
+			return fmt.Errorf("%s can be changed by users other than root", path)
+		}
+		if filepath.Dir(path) == path {
+			return nil
+		}
+		path = filepath.Dir(path)
+	}
+}
+
+// certificatePrincipals returns the principals of the SSH certificate the
+// user authenticated with, from the file sshd names in SSH_USER_AUTH when
+// ExposeAuthInfo is enabled
+func certificatePrincipals() ([]string, error) {
+	path := os.Getenv("SSH_USER_AUTH")
+	if path == "" {
+		return nil, fmt.Errorf("SSH_USER_AUTH is not set, enable ExposeAuthInfo in sshd")
+	}
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificate string
+	for _, line := range strings.Split(string(text), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "publickey" && strings.Contains(fields[1], "-cert-") {
+			certificate = fields[1] + " " + fields[2]
+		}
+	}
+	if certificate == "" {
+		return nil, fmt.Errorf("no certificate was used to authenticate")
+	}
+
+	file, err := ioutil.TempFile("", "dockersshell-cert")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(certificate + "\n")
+	file.Close()
+
+	output, err := exec.Command("ssh-keygen", "-L", "-f", file.Name()).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// Principals are listed indented under their heading, up to the next
+	// heading
+	var principals []string
+	inPrincipals := false
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(line, ":") || strings.Contains(line, ": ") {
+			inPrincipals = strings.HasPrefix(line, "Principals:")
+			continue
+		}
+		if inPrincipals && line != "" && line != "(none)" {
+			principals = append(principals, line)
+		}
+	}
+	return principals, nil
+}
+
+// assignedProfile is the profile ssh_identity assigned to the user, if any,
+// which is the only one they may use
+var assignedProfile string
+
+// sshIdentity returns the user and the assigned profile when ssh_identity is
+// configured. With certificates, the first principal of the user's
+// certificate is the user and a profile:NAME principal assigns the profile.
+// With environment, DSSHELL_USER and DSSHELL_PROFILE, set by the
+// environment= option in authorized_keys, are used, but only once the
+// authorized_keys file is known to be root's alone.
+func sshIdentity(config *Config, user string) (string, string, error) {
+	var profile string
+	c := &config.SSHIdentity
+	if c.Certificates {
+		principals, err := certificatePrincipals()
+		if err != nil {
+			return "", "", err
+		}
+		user = ""
+		for _, principal := range principals {
+			if strings.HasPrefix(principal, "profile:") {
+				if profile == "" {
+					profile = strings.TrimPrefix(principal, "profile:")
+				}
+			} else if user == "" {
+				user = principal
+			}
+		}
+		if user == "" {
+			return "", "", fmt.Errorf("the certificate has no user principal")
+		}
+	}
+
+	if c.Environment {
+		path, err := c.authorizedKeys()
+		if err == nil {
+			err = rootOnly(path)
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("the environment can not be trusted: %s", err)
+		}
+		if name := os.Getenv("DSSHELL_USER"); name != "" {
+			user = name
+		}
+		if name := os.Getenv("DSSHELL_PROFILE"); name != "" {
+			profile = name
+		}
+	}
+	return user, profile, nil
+}
+
+// checkAssignedProfile defaults profile to the assigned one and refuses any
+// other
+func checkAssignedProfile(profile *string) error {
+	if assignedProfile == "" {
+		return nil
+	}
+	if *profile == "" {
+		*profile = assignedProfile
+	}
+	if *profile != assignedProfile {
+		return fmt.Errorf("you are assigned profile %s", assignedProfile)
+	}
+	return nil
+}
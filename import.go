@@ -0,0 +1,174 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"launchpad.net/goyaml"
+)
+
+// dockerContextEndpoints returns the endpoints of the docker CLI contexts.
+// Contexts reached over ssh:// are skipped, as the docker API client here
+// only speaks TCP and unix sockets.
+func dockerContextEndpoints() []string {
+	metas, _ := filepath.Glob(filepath.Join(os.Getenv("HOME"), ".docker", "contexts", "meta", "*", "meta.json"))
+
+	var endpoints []string
+	for _, path := range metas {
+		text, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		var meta struct {
+			Name      string
+			Endpoints map[string]struct{ Host string }
+		}
+		if err := json.Unmarshal(text, &meta); err != nil {
+			continue
+		}
+
+		host := meta.Endpoints["docker"].Host
+		switch {
+		case strings.HasPrefix(host, "tcp://"):
+			endpoints = append(endpoints, "http://"+strings.TrimPrefix(host, "tcp://"))
+		case strings.HasPrefix(host, "unix://"):
+			endpoints = append(endpoints, host)
+		case host != "":
+			status(colorYellow, "Skipping docker context %s: %s is not supported", meta.Name, host)
+		}
+	}
+	return endpoints
+}
+
+// sshConfigHosts returns the HostName of every Host in ~/.ssh/config whose
+// alias matches pattern
+func sshConfigHosts(pattern string) []string {
+	text, err := ioutil.ReadFile(sshConfigPath())
+	if err != nil {
+		return nil
+	}
+
+	var hosts []string
+	var aliases []string
+	hostname := ""
+	flush := func() {
+		for _, alias := range aliases {
+			if matched, _ := filepath.Match(pattern, alias); matched && !strings.ContainsAny(alias, "*?") {
+				if hostname == "" {
+					hosts = append(hosts, alias)
+				} else {
+					hosts = append(hosts, hostname)
+				}
+				break
+			}
+		}
+	}
+
+	for _, line := range strings.Split(string(text), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "host":
+			flush()
+			aliases, hostname = fields[1:], ""
+		case "match":
+			flush()
+			aliases, hostname = nil, ""
+		case "hostname":
+			hostname = fields[1]
+		}
+	}
+	flush()
+	return hosts
+}
+
+// runImport recreates a session from a definition written by export when
+// given a file. Otherwise it seeds endpoints from docker contexts and, with
+// -ssh-hosts, from matching hosts in ~/.ssh/config. The endpoints are
+// printed, or added to the user config with -write.
+func runImport(config *Config, user string, args []string) {
+	fs := findCommand("import").flags()
+	pattern := fs.String("ssh-hosts", "", "Also import ~/.ssh/config hosts matching this pattern")
+	port := fs.Int("port", 4243, "Docker API port of imported ssh hosts")
+	write := fs.Bool("write", false, "Add the endpoints to the user config")
+	var opts sessionOptions
+	fs.StringVar(&opts.Name, "name", "", "Name for the imported session, instead of the exported one")
+	fs.StringVar(&opts.Region, "region", "", "Region to create the imported session in")
+	fs.BoolVar(&opts.SSHConfig, "ssh-config", config.SSHConfig, "Add a Host entry for the imported session")
+	fs.StringVar(&opts.Reason, "reason", "", "Why you need the session, when a justification is required")
+	fs.StringVar(&opts.Ticket, "ticket", "", "Ticket the session is for, when a justification is required")
+	fs.Parse(args)
+
+	if fs.NArg() == 1 {
+		importSession(config, user, fs.Arg(0), opts)
+		return
+	}
+
+	endpoints := dockerContextEndpoints()
+	if *pattern != "" {
+		for _, host := range sshConfigHosts(*pattern) {
+			endpoints = append(endpoints, fmt.Sprintf("http://%s:%d", host, *port))
+		}
+	}
+
+	if !*write {
+		for _, endpoint := range endpoints {
+			fmt.Println(endpoint)
+		}
+		return
+	}
+
+	var userConfig Config
+	path := userConfigPath()
+	if text, err := ioutil.ReadFile(path); err == nil {
+		if err := goyaml.Unmarshal(text, &userConfig); err != nil {
+			log.Fatal(msg("Unable to parse %s: %s\n", path, err))
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, endpoint := range userConfig.Endpoints {
+		seen[endpoint] = true
+	}
+	for _, endpoint := range endpoints {
+		if !seen[endpoint] {
+			seen[endpoint] = true
+			userConfig.Endpoints = append(userConfig.Endpoints, endpoint)
+			status(colorGreen, "Imported %s", endpoint)
+		}
+	}
+
+	text, err := goyaml.Marshal(&userConfig)
+	if err != nil {
+		log.Fatal(msg("Unable to render config: %s\n", err))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Fatal(msg("Unable to write config: %s\n", err))
+	}
+	if err := ioutil.WriteFile(path, text, 0600); err != nil {
+		log.Fatal(msg("Unable to write config: %s\n", err))
+	}
+}
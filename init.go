@@ -0,0 +1,92 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"launchpad.net/goyaml"
+)
+
+// userConfigPath is the per user config, read after /etc/dockersshell.yaml
+func userConfigPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".dockersshell", "config.yaml")
+}
+
+// ask prompts for a value on stderr, returning def for an empty answer
+func ask(reader *bufio.Reader, question string, def string) string {
+	fmt.Fprintf(os.Stderr, "%s [%s]: ", msg(question), def)
+	answer, _ := reader.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def
+	}
+	return answer
+}
+
+// runInit asks for the basic settings, checks that the endpoints answer and
+// writes them to the user config
+func runInit(config *Config, user string, args []string) {
+	fs := findCommand("init").flags()
+	force := fs.Bool("force", false, "Overwrite an existing config")
+	fs.Parse(args)
+
+	path := userConfigPath()
+	if _, err := os.Stat(path); err == nil && !*force {
+		log.Fatal(msg("%s already exists, pass -force to overwrite it", path))
+	}
+
+	noPrompt("init")
+	reader := bufio.NewReader(os.Stdin)
+	var answers Config
+	for _, endpoint := range strings.Split(ask(reader, "Docker endpoints, separated by commas", strings.Join(config.Endpoints, ",")), ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			answers.Endpoints = append(answers.Endpoints, endpoint)
+		}
+	}
+	answers.Image = ask(reader, "Session image", config.Image)
+	answers.User = ask(reader, "User to log in to sessions as", config.User)
+
+	for _, endpoint := range answers.Endpoints {
+		client, err := dockerClient(endpoint)
+		if err == nil {
+			err = client.Ping()
+		}
+		if err != nil {
+			status(colorYellow, "Unable to reach %s: %s", endpoint, err)
+			continue
+		}
+		status(colorGreen, "Reached %s", endpoint)
+	}
+
+	text, err := goyaml.Marshal(&answers)
+	if err != nil {
+		log.Fatal(msg("Unable to render config: %s\n", err))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Fatal(msg("Unable to write config: %s\n", err))
+	}
+	if err := ioutil.WriteFile(path, text, 0600); err != nil {
+		log.Fatal(msg("Unable to write config: %s\n", err))
+	}
+	status(colorGreen, "Wrote %s", path)
+}
@@ -0,0 +1,130 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// sshPort returns the published port of a container's sshd, if any
+func sshPort(container docker.APIContainers) int64 {
+	for _, port := range container.Ports {
+		if port.PrivatePort == 22 && port.Type == "tcp" && port.PublicPort != 0 {
+			return port.PublicPort
+		}
+	}
+	return 0
+}
+
+// inventory builds Ansible dynamic inventory of running sessions, grouped by
+// owner and profile. Sessions of other users are included with all, and
+// their host names are prefixed with the owner to keep them unique.
+func inventory(config *Config, user string, all bool) map[string]interface{} {
+	hosts := []string{}
+	groups := map[string][]string{}
+	hostvars := map[string]map[string]interface{}{}
+
+	for _, endpoint := range config.Endpoints {
+		client, err := dockerClient(endpoint)
+		if err != nil {
+			continue
+		}
+
+		containers, err := managedContainers(client)
+		if err != nil {
+			continue
+		}
+
+		for _, container := range containers {
+			poolLabels(&container)
+			labels := container.Labels
+			owner := labels[labelOwner]
+			port := sshPort(container)
+			if owner == "" || port == 0 || (!all && owner != user) {
+				continue
+			}
+
+			host := labels[labelName]
+			if all {
+				host = owner + "." + host
+			}
+			hosts = append(hosts, host)
+			groups["owner_"+owner] = append(groups["owner_"+owner], host)
+			if profile := labels[labelProfile]; profile != "" {
+				groups["profile_"+profile] = append(groups["profile_"+profile], host)
+			}
+			for label, value := range labels {
+				if strings.HasPrefix(label, labelTag) {
+					group := "tag_" + strings.TrimPrefix(label, labelTag) + "_" + value
+					groups[group] = append(groups[group], host)
+				}
+			}
+
+			hostvars[host] = map[string]interface{}{
+				"ansible_host":          connectHost(endpoint),
+				"ansible_port":          port,
+				"ansible_user":          sessionProfile(config, labels).User,
+				"dockersshell_name":     labels[labelName],
+				"dockersshell_owner":    owner,
+				"dockersshell_endpoint": endpoint,
+				"dockersshell_profile":  labels[labelProfile],
+				"dockersshell_image":    labels[labelImage],
+			}
+		}
+	}
+
+	result := map[string]interface{}{
+		"all":   map[string]interface{}{"hosts": hosts},
+		"_meta": map[string]interface{}{"hostvars": hostvars},
+	}
+	for group, members := range groups {
+		result[group] = map[string]interface{}{"hosts": members}
+	}
+	return result
+}
+
+// runInventory implements the Ansible dynamic inventory script protocol.
+// Host variables are returned in _meta, so --host has nothing to add.
+func runInventory(config *Config, user string, args []string) {
+	fs := findCommand("inventory").flags()
+	all := fs.Bool("all", false, "Include the sessions of every user")
+	list := fs.Bool("list", false, "List all sessions, which is also done without -host")
+	host := fs.String("host", "", "Print the variables of a single host")
+	fs.Parse(args)
+
+	if *list && *host != "" {
+		log.Fatal(msg("Only one of -list and -host can be given"))
+	}
+	if *all {
+		requireAdmin(config, user, "list other users' sessions")
+	}
+
+	var result interface{} = map[string]interface{}{}
+	if *host == "" {
+		result = inventory(config, user, *all)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Fatal(msg("Unable to write inventory: %s\n", err))
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/fsouza/go-dockerclient"
+	"launchpad.net/goyaml"
+)
+
+// journalEntry records a session container being set up or in use by a
+// dockersshell process, so that it can be removed if the process dies
+// without tearing it down
+type journalEntry struct {
+	Endpoint string `yaml:"endpoint"`
+	Name     string `yaml:"name"`
+	Host     string `yaml:"host"`
+	PID      int    `yaml:"pid"`
+}
+
+func journalDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".dockersshell", "journal")
+}
+
+// journalBegin records that the container name is about to be created on
+// endpoint by this process
+func journalBegin(endpoint string, name string) {
+	host, _ := os.Hostname()
+	text, err := goyaml.Marshal(&journalEntry{endpoint, name, host, os.Getpid()})
+	if err == nil {
+		if err = os.MkdirAll(journalDir(), 0700); err == nil {
+			err = ioutil.WriteFile(filepath.Join(journalDir(), name), text, 0600)
+		}
+	}
+	if err != nil {
+		log.Print(msg("Unable to write journal: %s\n", err))
+	}
+}
+
+// journalEnd forgets the container name, once it has been torn down or
+// handed over to the user
+func journalEnd(name string) {
+	os.Remove(filepath.Join(journalDir(), name))
+}
+
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	return err == nil && process.Signal(syscall.Signal(0)) == nil
+}
+
+// recoverJournal removes the containers of dockersshell processes on this
+// host that died before tearing down their session
+func recoverJournal(config *Config) {
+	paths, _ := filepath.Glob(filepath.Join(journalDir(), "*"))
+	host, _ := os.Hostname()
+	for _, path := range paths {
+		text, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry journalEntry
+		if err := goyaml.Unmarshal(text, &entry); err != nil {
+			continue
+		}
+
+		// Home directories may be shared between bastions, and only
+		// processes on this one can be checked
+		if entry.Host != host || processAlive(entry.PID) {
+			continue
+		}
+
+		client, err := dockerClient(entry.Endpoint)
+		if err != nil {
+			continue
+		}
+		container, err := client.InspectContainer(entry.Name)
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			os.Remove(path)
+			continue
+		} else if err != nil {
+			log.Print(msg("Unable to recover %s on %s: %s\n", entry.Name, entry.Endpoint, err))
+			continue
+		}
+
+		// Anyone can write a journal entry in their home, so only
+		// their own sessions are removed
+		if err := checkOwner(config, invoker, container.Config.Labels); err != nil {
+			log.Print(msg("Unable to recover %s on %s: %s\n", entry.Name, entry.Endpoint, err))
+			os.Remove(path)
+			continue
+		}
+
+		client.StopContainer(container.ID, 0)
+		if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true}); err != nil {
+			log.Print(msg("Unable to recover %s on %s: %s\n", entry.Name, entry.Endpoint, err))
+			continue
+		}
+		status(colorGreen, "Removed %s left behind on %s", entry.Name, entry.Endpoint)
+		deregisterSession(config, container.Config.Labels[labelOwner], container.Config.Labels[labelName])
+		audit(config, eventRemove, map[string]string{"user": invoker, "session": entry.Name, "endpoint": entry.Endpoint, "detail": "recovered"})
+		os.Remove(path)
+	}
+}
+
+func runRecover(config *Config, user string, args []string) {
+	findCommand("recover").flags().Parse(args)
+	recoverJournal(config)
+}
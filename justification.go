@@ -0,0 +1,123 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// JustificationConfig makes users say why they need a session before one is
+// created for them. The reason and ticket are recorded in the audit log.
+type JustificationConfig struct {
+	Required bool     `yaml:"required,omitempty"`
+	Profiles []string `yaml:"profiles,omitempty"`
+	Ticket   string   `yaml:"ticket_pattern,omitempty"`
+	Webhook  string   `yaml:"webhook,omitempty"`
+}
+
+// applies reports whether sessions from the named profile need a
+// justification. Without profiles it applies to all of them.
+func (c *JustificationConfig) applies(profile string) bool {
+	if !c.Required {
+		return false
+	}
+	if len(c.Profiles) == 0 {
+		return true
+	}
+	for _, name := range c.Profiles {
+		if name == profile {
+			return true
+		}
+	}
+	return false
+}
+
+var justificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// approve asks the webhook whether the justification is acceptable. Anything
+// but a 2xx response refuses it, with the response body as the reason.
+func (c *JustificationConfig) approve(user string, profile string, reason string, ticket string) error {
+	body, err := json.Marshal(map[string]string{"user": user, "profile": profile, "reason": reason, "ticket": ticket})
+	if err != nil {
+		return err
+	}
+	resp, err := justificationClient.Post(c.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		text, _ := ioutil.ReadAll(resp.Body)
+		if message := strings.TrimSpace(string(text)); message != "" {
+			return fmt.Errorf("%s", message)
+		}
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// justify makes sure a session from the profile comes with a reason, and a
+// ticket matching ticket_pattern when one is set, prompting for whatever was
+// not given
+func justify(config *Config, user string, opts *sessionOptions) {
+	c := &config.Justification
+	if !c.applies(opts.Profile) {
+		return
+	}
+
+	if opts.Reason == "" || (c.Ticket != "" && opts.Ticket == "") {
+		noPrompt("Justification")
+		reader := bufio.NewReader(os.Stdin)
+		if opts.Reason == "" {
+			fmt.Fprint(os.Stderr, msg("Reason for the session: "))
+			text, _ := reader.ReadString('\n')
+			opts.Reason = strings.TrimSpace(text)
+		}
+		if c.Ticket != "" && opts.Ticket == "" {
+			fmt.Fprint(os.Stderr, msg("Ticket: "))
+			text, _ := reader.ReadString('\n')
+			opts.Ticket = strings.TrimSpace(text)
+		}
+	}
+
+	var err error
+	switch {
+	case opts.Reason == "":
+		err = fmt.Errorf("a reason is required")
+	case c.Ticket != "":
+		var pattern *regexp.Regexp
+		if pattern, err = regexp.Compile(c.Ticket); err == nil && !pattern.MatchString(opts.Ticket) {
+			err = fmt.Errorf("%q is not a valid ticket", opts.Ticket)
+		}
+	}
+	if err == nil && c.Webhook != "" {
+		err = c.approve(user, opts.Profile, opts.Reason, opts.Ticket)
+	}
+	if err != nil {
+		audit(config, eventDenied, map[string]string{"user": user, "profile": opts.Profile, "reason": err.Error(), "justification": opts.Reason, "ticket": opts.Ticket})
+		log.Fatal(msg("Unable to justify the session: %s\n", err))
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// runLogs writes what a session's container printed, sshd and the image's
+// init scripts, from whichever endpoint runs it. -f keeps following it.
+func runLogs(config *Config, user string, args []string) {
+	fs := findCommand("logs").flags()
+	owner := fs.String("owner", user, "Owner of the session, for admins")
+	follow := fs.Bool("f", false, "Keep writing new output until interrupted")
+	tail := fs.Int("tail", -1, "Only write this many of the last lines")
+	timestamps := fs.Bool("timestamps", false, "Prefix every line with its time")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+
+	s := findSession(config.Endpoints, *owner, name)
+	if s == nil {
+		log.Fatal(msg("No session named %s", name))
+	}
+	if err := checkOwner(config, user, s.Container.Labels); err != nil {
+		log.Fatal(msg("Unable to read the logs of %s: %s\n", name, err))
+	}
+
+	lines := "all"
+	if *tail >= 0 {
+		lines = strconv.Itoa(*tail)
+	}
+	err := s.Client.Logs(docker.LogsOptions{
+		Container:    s.Container.ID,
+		OutputStream: os.Stdout,
+		ErrorStream:  os.Stderr,
+		Stdout:       true,
+		Stderr:       true,
+		Follow:       *follow,
+		Tail:         lines,
+		Timestamps:   *timestamps,
+	})
+	if err != nil {
+		log.Fatal(msg("Unable to read the logs of %s: %s\n", name, err))
+	}
+}
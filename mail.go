@@ -0,0 +1,120 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	Server       string            `yaml:"server,omitempty"`
+	From         string            `yaml:"from,omitempty"`
+	Username     string            `yaml:"username,omitempty"`
+	Password     string            `yaml:"password,omitempty"`
+	Domain       string            `yaml:"domain,omitempty"`
+	Emails       map[string]string `yaml:"emails,omitempty"`
+	NotifyBefore int               `yaml:"notify_before,omitempty"`
+	Renew        string            `yaml:"renew,omitempty"`
+}
+
+// email returns the address to notify for owner, if one is known
+func (c *SMTPConfig) email(owner string) string {
+	if address, ok := c.Emails[owner]; ok {
+		return address
+	}
+	if c.Domain != "" {
+		return fmt.Sprintf("%s@%s", owner, c.Domain)
+	}
+	return ""
+}
+
+func (c *SMTPConfig) notifyBefore() int64 {
+	if c.NotifyBefore == 0 {
+		return 3600
+	}
+	return int64(c.NotifyBefore)
+}
+
+// sendMail emails owner, if an address is known for them
+func sendMail(c *SMTPConfig, owner string, subject string, body string) error {
+	to := c.email(owner)
+	if to == "" {
+		return fmt.Errorf("no email address for %s", owner)
+	}
+
+	var auth smtp.Auth
+	if c.Username != "" {
+		host, _, _ := net.SplitHostPort(c.Server)
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", c.From, to, subject, body)
+	return smtp.SendMail(c.Server, auth, c.From, []string{to}, []byte(message))
+}
+
+func sendCleanupNotice(c *SMTPConfig, owner string, name string, endpoint string, removal time.Time) error {
+	body := msg("Your dockersshell session %s on %s will be removed at %s.", name, endpoint, removal.Format(time.RFC1123))
+	if c.Renew != "" {
+		body += "\r\n\r\n" + msg("To keep it, renew it: %s", c.Renew)
+	}
+	return sendMail(c, owner, msg("dockersshell session %s will be removed soon", name), body)
+}
+
+// noticeState tracks which containers have already been notified, so each
+// owner is only emailed once per session across cleanup runs
+type noticeState struct {
+	path     string
+	notified map[string]bool
+	seen     map[string]bool
+}
+
+func loadNoticeState(dir string) *noticeState {
+	state := &noticeState{
+		path:     filepath.Join(dir, "notified"),
+		notified: map[string]bool{},
+		seen:     map[string]bool{},
+	}
+
+	text, err := ioutil.ReadFile(state.path)
+	if err == nil {
+		for _, id := range strings.Fields(string(text)) {
+			state.notified[id] = true
+		}
+	}
+	return state
+}
+
+// save records the notified containers that still exist
+func (s *noticeState) save() error {
+	var ids []string
+	for id := range s.notified {
+		if s.seen[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, []byte(strings.Join(ids, "\n")), 0644)
+}
@@ -0,0 +1,92 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"launchpad.net/goyaml"
+)
+
+// Messages are looked up by their English format string, so a catalog is a
+// YAML mapping of English format strings to translated ones, and a message
+// missing from the catalog is simply shown in English.
+var catalog map[string]string
+
+var terse bool
+
+// terseMessages replaces the chattier progress messages in terse mode. A
+// replacement takes the same arguments, in the same order, as the message.
+var terseMessages = map[string]string{
+	"Using endpoint %s":            "endpoint %s",
+	"Waiting for %s:%s":            "waiting %s:%s",
+	"Connecting to %s:%s with ssh": "connecting %s:%s",
+	"Removed session":              "removed",
+	"Cleaned up %s on %s":          "cleaned %s %s",
+}
+
+// language returns the configured language, falling back to the one in LANG
+func language(config *Config) string {
+	if config.Language != "" {
+		return config.Language
+	}
+
+	lang := os.Getenv("LANG")
+	lang = strings.SplitN(lang, ".", 2)[0]
+	lang = strings.SplitN(lang, "_", 2)[0]
+	if lang == "C" || lang == "POSIX" {
+		return ""
+	}
+	return lang
+}
+
+// loadMessages reads the catalog for the configured language from the
+// messages directory, if there is one
+func loadMessages(config *Config) {
+	terse = config.Terse
+
+	lang := language(config)
+	if lang == "" || lang == "en" {
+		return
+	}
+
+	dir := config.Messages
+	if dir == "" {
+		dir = "/etc/dockersshell/messages"
+	}
+
+	text, err := ioutil.ReadFile(filepath.Join(dir, lang+".yaml"))
+	if err != nil {
+		return
+	}
+	goyaml.Unmarshal(text, &catalog)
+}
+
+// msg formats a user facing message, translated or shortened as configured
+func msg(format string, args ...interface{}) string {
+	key := strings.TrimRight(format, "\n")
+	suffix := format[len(key):]
+	if short, ok := terseMessages[key]; ok && terse {
+		format = short + suffix
+	} else if translated, ok := catalog[key]; ok {
+		format = translated + suffix
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,220 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// migrate moves a session container to target by committing it, copying the
+// image across and recreating the container there with the same name and
+// labels. Volumes are not copied and running processes do not survive. The
+// session is only removed from source once its copy runs on target, with the
+// host settings and network limits of its profile as they apply there.
+func migrate(config *Config, source string, client *docker.Client, id string, target string) (string, error) {
+	if target == source {
+		return "", fmt.Errorf("%s already runs on %s", id, target)
+	}
+	inspect, err := client.InspectContainer(id)
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimPrefix(inspect.Name, "/")
+	repository := "dockersshell-migrate/" + name
+	profile := sessionProfile(config, inspect.Config.Labels)
+
+	status(colorBlue, "Committing %s on %s", name, source)
+	image, err := client.CommitContainer(docker.CommitContainerOptions{Container: id, Repository: repository, Tag: "latest"})
+	if err != nil {
+		return "", err
+	}
+	defer client.RemoveImage(image.ID)
+
+	destination, err := dockerClient(target)
+	if err != nil {
+		return "", err
+	}
+
+	status(colorBlue, "Copying %s to %s", name, target)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(client.ExportImage(docker.ExportImageOptions{Name: repository, OutputStream: writer}))
+	}()
+	if err := destination.LoadImage(docker.LoadImageOptions{InputStream: reader}); err != nil {
+		reader.CloseWithError(err)
+		return "", err
+	}
+	reader.Close()
+	// The container keeps the image's layers once the tag is gone
+	defer destination.RemoveImageExtended(repository, docker.RemoveImageOptions{Force: true})
+
+	// The host config is built for the target from the profile, as devices,
+	// GPUs and ports of the source do not carry over
+	dockerConfig := *inspect.Config
+	dockerConfig.Image = repository
+	dockerConfig.Labels = map[string]string{}
+	for key, value := range inspect.Config.Labels {
+		if key != labelGPUs {
+			dockerConfig.Labels[key] = value
+		}
+	}
+	dockerConfig.Env = nil
+	for _, env := range inspect.Config.Env {
+		if strings.HasPrefix(env, "DSSHELL_ENDPOINT=") {
+			env = "DSSHELL_ENDPOINT=" + target
+		}
+		dockerConfig.Env = append(dockerConfig.Env, env)
+	}
+	host := profile.hostConfig()
+	bindPorts(&host, target)
+	if profile.GPUs != 0 {
+		containers, err := managedContainers(destination)
+		if err != nil {
+			return "", err
+		}
+		gpus := freeGPUs(target, containers)
+		if len(gpus) < profile.GPUs {
+			return "", fmt.Errorf("%s has %d free GPUs, %d are needed", target, len(gpus), profile.GPUs)
+		}
+		assignGPUs(&dockerConfig, &host, gpus[:profile.GPUs])
+	}
+	if err := profile.raw(&docker.Config{}, &host); err != nil {
+		return "", err
+	}
+
+	container, err := destination.CreateContainer(docker.CreateContainerOptions{Name: name, Config: &dockerConfig, HostConfig: &host})
+	if err != nil {
+		return "", err
+	}
+	abandon := func(err error) (string, error) {
+		destination.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+		return "", err
+	}
+	if err := destination.StartContainer(container.ID, &host); err != nil {
+		return abandon(err)
+	}
+	if err := limitNetwork(destination, container.ID, profile); err != nil {
+		return abandon(err)
+	}
+
+	if err := client.StopContainer(id, 0); err != nil {
+		return abandon(err)
+	}
+	if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: id}); err != nil {
+		client.StartContainer(id, inspect.HostConfig)
+		return abandon(err)
+	}
+
+	address, port := sessionAddress(target, destination, container.ID)
+	registerSession(config, dockerConfig.Labels[labelOwner], dockerConfig.Labels[labelName], address, port)
+	return container.ID, nil
+}
+
+// migrationTarget picks where to move a session of owner when no target
+// was given
+func migrationTarget(config *Config, source string, owner string) string {
+	target := place(config, probe(config, config.Endpoints, source), owner)
+	if target == "" {
+		fail(ErrNoEndpoints, msg("No acceptable endpoints found"))
+	}
+	return target
+}
+
+func runMigrate(config *Config, user string, args []string) {
+	fs := findCommand("migrate").flags()
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		log.Fatal(msg("Expected a session name and optional endpoint"))
+	}
+
+	name := fs.Arg(0)
+	s := findSession(config.Endpoints, user, name)
+	if s == nil {
+		log.Fatal(msg("No session named %s", name))
+	}
+
+	target := fs.Arg(1)
+	if target == "" {
+		target = migrationTarget(config, s.Endpoint, user)
+	}
+
+	oldHost, oldPort := sessionAddress(s.Endpoint, s.Client, s.Container.ID)
+	id, err := migrate(config, s.Endpoint, s.Client, s.Container.ID, target)
+	if err != nil {
+		log.Fatal(msg("Unable to migrate %s: %s\n", name, err))
+	}
+	if err := forgetHostKeys(oldHost, oldPort); err != nil {
+		fmt.Print(msg("Unable to update known hosts: %s\n", err))
+	}
+
+	destination, _ := dockerClient(target)
+	host, port := sessionAddress(target, destination, id)
+	publishHostKeys(destination, id, host, port)
+	if err := updateSSHConfigEntry(name, target, sessionProfile(config, s.Container.Labels).User, host, port); err != nil {
+		fmt.Print(msg("Unable to update ssh config: %s\n", err))
+	}
+	status(colorGreen, "Migrated %s to %s", name, target)
+	fmt.Printf("%s %s %s\n", name, host, port)
+}
+
+// runRebalance lists the sessions that are not on their owner's affinity
+// endpoint, after endpoints were added or drained, and moves them with
+// -apply
+func runRebalance(config *Config, user string, args []string) {
+	fs := findCommand("rebalance").flags()
+	apply := fs.Bool("apply", false, "Migrate the misplaced sessions")
+	fs.Parse(args)
+
+	if !config.Affinity {
+		log.Fatal(msg("Rebalancing requires affinity to be enabled"))
+	}
+	if *apply {
+		requireAdmin(config, user, "migrate other users' sessions")
+	}
+
+	states := probe(config, config.Endpoints, "")
+	fmt.Printf("%-30s %-20s %-30s %-30s\n", "NAME", "OWNER", "ENDPOINT", "TARGET")
+	for _, endpoint := range config.Endpoints {
+		client, sessions, err := endpointSessions(endpoint)
+		if err != nil {
+			log.Print(msg("Unable to list sessions on %s: %s\n", endpoint, err))
+			continue
+		}
+
+		for _, container := range sessions {
+			owner := container.Labels[labelOwner]
+			target := affinity(states, owner)
+			if target == "" || target == endpoint {
+				continue
+			}
+
+			fmt.Printf("%-30s %-20s %-30s %-30s\n", container.Labels[labelName], owner, endpoint, target)
+			if *apply {
+				if _, err := migrate(config, endpoint, client, container.ID, target); err != nil {
+					log.Print(msg("Unable to migrate %s: %s\n", container.Labels[labelName], err))
+					continue
+				}
+				status(colorGreen, "Migrated %s to %s", container.Labels[labelName], target)
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// defaultNetworkImage is used to apply network limits when a profile does not
+// name an image with iptables and tc in it
+const defaultNetworkImage = "nicolaka/netshoot"
+
+// resolvers prints the nameservers of the session, of one address family, for
+// the egress rules. The helper container shares the session's resolv.conf
+// along with its network namespace.
+const resolvers = `awk '$1 == "nameserver" && $2 %s /:/ { sub(/%%.*/, "", $2); print $2 }' /etc/resolv.conf`
+
+// egressRules returns the shell script that limits outgoing connections of a
+// network namespace to the allowed destinations. Entries are host, host:port,
+// address or CIDR; host names are resolved when the session is created. DNS
+// to the session's nameservers is always allowed, so names still resolve in
+// the session, but nothing else can be reached on port 53.
+func egressRules(allow []string) (string, error) {
+	var script bytes.Buffer
+	for _, command := range []string{"iptables", "ip6tables"} {
+		match := "!~"
+		if command == "ip6tables" {
+			script.WriteString("if [ -e /proc/net/if_inet6 ]; then\n")
+			match = "~"
+		}
+		fmt.Fprintf(&script, "%s -A OUTPUT -o lo -j ACCEPT\n", command)
+		fmt.Fprintf(&script, "%s -A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT\n", command)
+		fmt.Fprintf(&script, "for ns in $(%s); do\n", fmt.Sprintf(resolvers, match))
+		fmt.Fprintf(&script, "\t%s -A OUTPUT -d \"$ns\" -p udp --dport 53 -j ACCEPT\n", command)
+		fmt.Fprintf(&script, "\t%s -A OUTPUT -d \"$ns\" -p tcp --dport 53 -j ACCEPT\n", command)
+		script.WriteString("done\n")
+
+		for _, entry := range allow {
+			host, port, err := net.SplitHostPort(entry)
+			if err != nil {
+				host, port = entry, ""
+			}
+			destinations, err := egressDestinations(host)
+			if err != nil {
+				return "", err
+			}
+			for _, destination := range destinations {
+				if strings.Contains(destination, ":") != (command == "ip6tables") {
+					continue
+				}
+				rule := fmt.Sprintf("%s -A OUTPUT -d %s", command, destination)
+				if port != "" {
+					rule += " -p tcp --dport " + port
+				}
+				script.WriteString(rule + " -j ACCEPT\n")
+			}
+		}
+		fmt.Fprintf(&script, "%s -A OUTPUT -j REJECT\n", command)
+	}
+	script.WriteString("fi\n")
+	return script.String(), nil
+}
+
+// egressDestinations returns the addresses or networks a host allows
+func egressDestinations(host string) ([]string, error) {
+	if _, network, err := net.ParseCIDR(host); err == nil {
+		return []string{network.String()}, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	var destinations []string
+	for _, ip := range ips {
+		destinations = append(destinations, ip.String())
+	}
+	return destinations, nil
+}
+
+// bandwidthRules returns the shell script that limits the traffic of the
+// session's interface to rate, a tc rate such as 10mbit, in both directions.
+// Outgoing traffic is shaped, incoming traffic over the rate is dropped so
+// TCP senders back off.
+func bandwidthRules(rate string) string {
+	return fmt.Sprintf(`tc qdisc add dev eth0 root tbf rate %[1]s burst 256kb latency 400ms
+tc qdisc add dev eth0 handle ffff: ingress
+tc filter add dev eth0 parent ffff: protocol all u32 match u32 0 0 police rate %[1]s burst 256kb drop flowid :1
+`, rate)
+}
+
+// checkNetwork refuses network limits for sessions on the host network,
+// where they would apply to the endpoint itself
+func (p *Profile) checkNetwork() error {
+	if (len(p.Egress) != 0 || p.Bandwidth != "") && p.Network == "host" {
+		return fmt.Errorf("egress and bandwidth cannot be limited on the host network")
+	}
+	return nil
+}
+
+// limitNetwork applies the profile's egress allowlist and bandwidth limit to
+// a session. They are set from a short lived helper container that joins the
+// session's network namespace with NET_ADMIN, which the session itself lacks
+// unless it is privileged.
+func limitNetwork(client *docker.Client, id string, profile *Profile) error {
+	if len(profile.Egress) == 0 && profile.Bandwidth == "" {
+		return nil
+	}
+	if err := profile.checkNetwork(); err != nil {
+		return err
+	}
+	// docker_host_config can set the network mode too
+	inspect, err := client.InspectContainer(id)
+	if err != nil {
+		return err
+	}
+	if inspect.HostConfig != nil && inspect.HostConfig.NetworkMode == "host" {
+		return fmt.Errorf("egress and bandwidth cannot be limited on the host network")
+	}
+
+	script := "set -e\n"
+	if len(profile.Egress) != 0 {
+		rules, err := egressRules(profile.Egress)
+		if err != nil {
+			return err
+		}
+		script += rules
+	}
+	if profile.Bandwidth != "" {
+		script += bandwidthRules(profile.Bandwidth)
+	}
+
+	image := profile.NetworkImage
+	if image == "" {
+		image = defaultNetworkImage
+	}
+	dockerConfig := docker.Config{
+		Image:      image,
+		User:       "root",
+		Entrypoint: []string{"sh", "-c"},
+		Cmd:        []string{script},
+	}
+	host := docker.HostConfig{NetworkMode: "container:" + id, CapAdd: []string{"NET_ADMIN"}}
+	helper, err := client.CreateContainer(docker.CreateContainerOptions{Config: &dockerConfig, HostConfig: &host})
+	if err != nil {
+		return err
+	}
+	defer client.RemoveContainer(docker.RemoveContainerOptions{ID: helper.ID, Force: true})
+
+	if err := client.StartContainer(helper.ID, &host); err != nil {
+		return err
+	}
+	code, err := client.WaitContainer(helper.ID)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("network limits exited with status %d", code)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Lifecycle events notifiers can subscribe to
+const (
+	eventCreate     = "create"
+	eventRemove     = "remove"
+	eventCleanup    = "cleanup"
+	eventDrain      = "drain"
+	eventPrivileged = "privileged"
+)
+
+type NotifierConfig struct {
+	Type    string   `yaml:"type"`
+	URL     string   `yaml:"url"`
+	Channel string   `yaml:"channel,omitempty"`
+	Events  []string `yaml:"events,omitempty"`
+}
+
+// wants reports whether the notifier subscribed to event; no events means all
+func (n *NotifierConfig) wants(event string) bool {
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NotifierConfig) payload(text string) (interface{}, error) {
+	switch n.Type {
+	case "slack":
+		payload := map[string]string{"text": text, "username": "dockersshell"}
+		if n.Channel != "" {
+			payload["channel"] = n.Channel
+		}
+		return payload, nil
+	case "teams":
+		return map[string]string{
+			"@type":    "MessageCard",
+			"@context": "http://schema.org/extensions",
+			"summary":  text,
+			"title":    "dockersshell",
+			"text":     text,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", n.Type)
+}
+
+var notifyClient = &http.Client{Timeout: 5 * time.Second}
+
+func (n *NotifierConfig) send(text string) error {
+	payload, err := n.payload(text)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := notifyClient.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", n.Type, resp.Status)
+	}
+	return nil
+}
+
+// notify sends a lifecycle event to every notifier subscribed to it. Failures
+// are logged but never interrupt the session.
+func notify(config *Config, event string, format string, args ...interface{}) {
+	text := msg(format, args...)
+	for i := range config.Notifiers {
+		n := &config.Notifiers[i]
+		if !n.wants(event) {
+			continue
+		}
+		if err := n.send(text); err != nil {
+			log.Print(msg("Unable to send %s notification: %s\n", n.Type, err))
+		}
+	}
+}
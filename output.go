@@ -0,0 +1,47 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	colorGreen  = "32"
+	colorYellow = "33"
+	colorBlue   = "34"
+)
+
+// plain disables colored output, set by -no-color/-plain or NO_COLOR
+var plain bool
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// status prints a progress message to stderr, colored when stderr is a
+// terminal. Status goes to stderr so that output meant for scripts, such as
+// that of create, stays parseable.
+func status(color string, format string, args ...interface{}) {
+	message := msg(format, args...)
+	if plain || os.Getenv("NO_COLOR") != "" || !isTerminal(os.Stderr) {
+		fmt.Fprintln(os.Stderr, message)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\033[%sm==>\033[0m %s\n", color, message)
+}
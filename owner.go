@@ -0,0 +1,65 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// isAdmin reports whether user may act on sessions of other users. Without
+// admins or admin_groups configured, nobody is an admin.
+func isAdmin(config *Config, user string) bool {
+	for _, admin := range config.Admins {
+		if admin == user {
+			return true
+		}
+	}
+	if len(config.AdminGroups) == 0 {
+		return false
+	}
+	groups, _ := userGroups(user)
+	for _, group := range groups {
+		for _, admin := range config.AdminGroups {
+			if group == admin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkOwner verifies that user owns the session with labels, or is an
+// admin, before it is destroyed or moved
+func checkOwner(config *Config, user string, labels map[string]string) error {
+	if labels[labelOwner] == user || isAdmin(config, user) {
+		return nil
+	}
+	audit(config, eventDenied, map[string]string{"user": user, "session": labels[labelName], "reason": "not the owner"})
+	return fmt.Errorf("%s does not own session %s", user, labels[labelName])
+}
+
+// requireAdmin exits unless user is an admin, for operations on every
+// user's sessions. They stay open to everyone until admins are configured.
+func requireAdmin(config *Config, user string, operation string) {
+	if len(config.Admins) == 0 && len(config.AdminGroups) == 0 {
+		return
+	}
+	if !isAdmin(config, user) {
+		audit(config, eventDenied, map[string]string{"user": user, "reason": operation + " needs an admin"})
+		log.Fatal(msg("Only admins may %s", operation))
+	}
+}
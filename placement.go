@@ -0,0 +1,113 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Placement policies decide what makes an endpoint loaded: the sessions on
+// it, every container on it, or what its containers actually use
+const (
+	placementManaged = "managed"
+	placementAll     = "all"
+	placementUsage   = "usage"
+)
+
+// placement is the configured placement policy
+var placement string
+
+func checkPlacement(policy string) error {
+	switch policy {
+	case "", placementManaged, placementAll, placementUsage:
+		return nil
+	}
+	return fmt.Errorf("unknown placement policy %s", policy)
+}
+
+// load is what endpoints are ranked by
+func (s endpointState) load() int {
+	if placement == placementUsage {
+		return s.Usage
+	}
+	return s.Containers
+}
+
+// measureLoad fills in what the placement policy needs beyond the sessions
+// on the endpoint
+func measureLoad(client *docker.Client, state *endpointState) {
+	switch placement {
+	case placementAll:
+		if containers, err := client.ListContainers(docker.ListContainersOptions{Limit: -1}); err == nil {
+			state.Containers = len(containers)
+		}
+	case placementUsage:
+		if usage, err := endpointUsage(client); err == nil {
+			state.Usage = usage
+		}
+	}
+}
+
+// endpointUsage returns the percentage of the host's CPU or memory in use
+// by its running containers, whichever is higher
+func endpointUsage(client *docker.Client) (int, error) {
+	info, err := client.Info()
+	if err != nil {
+		return 0, err
+	}
+	containers, err := client.ListContainers(docker.ListContainersOptions{Limit: -1})
+	if err != nil {
+		return 0, err
+	}
+
+	cpu, memory := containerUsage(client, containers)
+	var usage float64
+	if info.NCPU != 0 {
+		usage = cpu / float64(info.NCPU)
+	}
+	if info.MemTotal != 0 {
+		if m := float64(memory) / float64(info.MemTotal) * 100; m > usage {
+			usage = m
+		}
+	}
+	return int(usage), nil
+}
+
+// containerUsage returns the CPU percentage and the bytes of memory used by
+// containers together
+func containerUsage(client *docker.Client, containers []docker.APIContainers) (float64, uint64) {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var cpu float64
+	var memory uint64
+	for _, container := range containers {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if s := statsOnce(client, id); s != nil {
+				lock.Lock()
+				cpu += cpuPercent(s)
+				memory += s.MemoryStats.Usage
+				lock.Unlock()
+			}
+		}(container.ID)
+	}
+	wg.Wait()
+	return cpu, memory
+}
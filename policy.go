@@ -0,0 +1,174 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a span of hours on some days of the week, such as 09:00-17:00
+// on weekdays. Hours ending before they start run past midnight. Without days
+// every day is included, without hours the whole day.
+type TimeWindow struct {
+	Days     []string `yaml:"days,omitempty"`
+	Hours    string   `yaml:"hours,omitempty"`
+	Timezone string   `yaml:"timezone,omitempty"`
+}
+
+func parseClock(text string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(text))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether t falls in the window
+func (w *TimeWindow) contains(t time.Time) (bool, error) {
+	if w.Timezone != "" {
+		location, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return false, err
+		}
+		t = t.In(location)
+	}
+
+	if len(w.Days) != 0 {
+		day := strings.ToLower(t.Weekday().String()[:3])
+		found := false
+		for _, d := range w.Days {
+			if strings.ToLower(d) == day {
+				found = true
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+	if w.Hours == "" {
+		return true, nil
+	}
+
+	parts := strings.SplitN(w.Hours, "-", 2)
+	if len(parts) != 2 {
+		return false, fmt.Errorf("hours must be HH:MM-HH:MM, not %q", w.Hours)
+	}
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return false, err
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return false, err
+	}
+	now := t.Hour()*60 + t.Minute()
+	if end < start {
+		return now >= start || now < end, nil
+	}
+	return now >= start && now < end, nil
+}
+
+func (w *TimeWindow) String() string {
+	text := w.Hours
+	if len(w.Days) != 0 {
+		text = strings.Join(w.Days, ",") + " " + text
+	}
+	if w.Timezone != "" {
+		text += " " + w.Timezone
+	}
+	return strings.TrimSpace(text)
+}
+
+// PolicyConfig limits when sessions may be created. A policy applies to the
+// sessions of its users and groups from its profiles, where any of them that
+// are left out match everyone.
+type PolicyConfig struct {
+	Users      []string `yaml:"users,omitempty"`
+	Groups     []string `yaml:"groups,omitempty"`
+	Profiles   []string `yaml:"profiles,omitempty"`
+	TimeWindow `yaml:",inline"`
+}
+
+func listed(list []string, item string) bool {
+	for _, entry := range list {
+		if entry == item {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PolicyConfig) applies(user string, profile string) bool {
+	if len(p.Users) != 0 && !listed(p.Users, user) {
+		return false
+	}
+	if len(p.Profiles) != 0 && !listed(p.Profiles, profile) {
+		return false
+	}
+	if len(p.Groups) != 0 {
+		groups, _ := userGroups(user)
+		for _, group := range groups {
+			if listed(p.Groups, group) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// checkPolicies fails unless every policy that applies to user and profile
+// allows sessions at t
+func checkPolicies(config *Config, user string, profile string, t time.Time) error {
+	for i := range config.Policies {
+		p := &config.Policies[i]
+		if !p.applies(user, profile) {
+			continue
+		}
+		ok, err := p.contains(t)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("sessions are only allowed %s", p.String())
+		}
+	}
+	return nil
+}
+
+// enforcePolicies is checkPolicies for sessions created now
+func enforcePolicies(config *Config, user string, profile string) {
+	if err := checkPolicies(config, user, profile, time.Now()); err != nil {
+		audit(config, eventDenied, map[string]string{"user": user, "profile": profile, "reason": err.Error()})
+		log.Fatal(msg("Unable to create a session: %s\n", err))
+	}
+}
+
+// inMaintenance reports whether endpoint is in one of its maintenance
+// windows, when no sessions are placed on it
+func inMaintenance(endpoint string) bool {
+	for _, window := range endpointConfigs[endpoint].Maintenance {
+		if ok, err := window.contains(time.Now()); err != nil {
+			log.Print(msg("Unable to check the maintenance window of %s: %s\n", endpoint, err))
+		} else if ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// labelPool marks containers created ahead of time for the warm pool. Labels
+// cannot be changed once a container exists, so a claimed pool container is
+// identified by its name, user-stamp, like containers predating labels.
+const labelPool = "dockersshell.pool"
+
+// poolLabels adopts a claimed pool container as a session of its owner, based
+// on its user-stamp name
+func poolLabels(container *docker.APIContainers) {
+	if container.Labels[labelPool] != "true" || len(container.Names) != 1 {
+		return
+	}
+
+	name := strings.TrimPrefix(container.Names[0], "/")
+	if owner := sessionOwner(name, container.Labels); owner != "" {
+		container.Labels[labelOwner] = owner
+		container.Labels[labelName] = name
+	}
+}
+
+// sessionOwner returns the owner of the session container named name. Pool
+// containers were created before their owner was known, so once claimed
+// they only carry it in their name.
+func sessionOwner(name string, labels map[string]string) string {
+	if labels[labelPool] != "true" {
+		return labels[labelOwner]
+	}
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "-")
+	if len(parts) != 2 {
+		return ""
+	}
+	return parts[0]
+}
+
+func pooledContainers(client *docker.Client) ([]docker.APIContainers, error) {
+	return client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Limit:   -1,
+		Filters: map[string][]string{"label": {labelPool + "=true"}, "status": {"created"}},
+	})
+}
+
+// pooledEnv writes the session environment into a claimed pool container,
+// which was created before its session was known, for pam_env and login
+// shells to pick up
+const pooledEnv = `mkdir -p /etc/profile.d
+for env in "$@"; do
+	printf '%s\n' "$env" >> /etc/environment
+	printf "export '%s'\n" "$env" >> /etc/profile.d/dockersshell.sh
+done
+`
+
+// claimPooled starts a pool container on the endpoint and renames it to name.
+// Starting is what claims a container: when two sessions race for the same
+// one, the loser gets ContainerAlreadyRunning and moves on to the next.
+func claimPooled(config *Config, endpoint string, client *docker.Client, name string) *docker.Container {
+	containers, err := pooledContainers(client)
+	if err != nil {
+		return nil
+	}
+
+	host := config.Profile.hostConfig()
+	bindPorts(&host, endpoint)
+	for _, pooled := range containers {
+		err := client.StartContainer(pooled.ID, &host)
+		if _, ok := err.(*docker.ContainerAlreadyRunning); ok {
+			continue
+		} else if err != nil {
+			log.Print(msg("Unable to start pooled container: %s\n", err))
+			continue
+		}
+
+		if err := client.RenameContainer(docker.RenameContainerOptions{ID: pooled.ID, Name: name}); err != nil {
+			log.Print(msg("Unable to rename pooled container: %s\n", err))
+		}
+
+		container, err := client.InspectContainer(pooled.ID)
+		if err != nil {
+			log.Fatal(msg("Unable to inspect container: %s\n", err))
+		}
+		return container
+	}
+	return nil
+}
+
+// fillPool tops up the warm pool of created but unstarted containers on an
+// endpoint
+func fillPool(config *Config, client *docker.Client, endpoint string) {
+	if config.WarmPool == 0 {
+		return
+	}
+
+	containers, err := pooledContainers(client)
+	if err != nil {
+		log.Print(msg("Unable to list pooled containers on %s: %s\n", endpoint, err))
+		return
+	}
+
+	for i := len(containers); i < config.WarmPool; i++ {
+		dockerConfig := docker.Config{
+			Image:  config.Image,
+			Labels: map[string]string{labelManaged: "true", labelPool: "true"},
+		}
+		name := fmt.Sprintf("dockersshell-pool-%d", time.Now().UnixNano())
+		host := config.Profile.hostConfig()
+		bindPorts(&host, endpoint)
+		opts := docker.CreateContainerOptions{Name: name, Config: &dockerConfig, HostConfig: &host}
+		if _, err := client.CreateContainer(opts); err != nil {
+			log.Print(msg("Unable to create pooled container on %s: %s\n", endpoint, err))
+			return
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// PreflightConfig lists what an endpoint must offer before a session of the
+// profile is created on it, so that a shortfall is reported plainly rather
+// than as whatever error the docker daemon gives
+type PreflightConfig struct {
+	MinFreeMemory  string   `yaml:"min_free_memory,omitempty"`
+	EndpointLabels []string `yaml:"endpoint_labels,omitempty"`
+	Files          []string `yaml:"files,omitempty"`
+}
+
+func (c *PreflightConfig) empty() bool {
+	return c.MinFreeMemory == "" && len(c.EndpointLabels) == 0 && len(c.Files) == 0
+}
+
+// parseSize parses a number of bytes with an optional k, m, g or t suffix,
+// in powers of 1024 as docker does
+func parseSize(size string) (uint64, error) {
+	text := strings.TrimSuffix(strings.ToLower(size), "b")
+	shift := uint(0)
+	if i := strings.IndexAny(text, "kmgt"); i != -1 && i == len(text)-1 {
+		shift = 10 * uint(strings.IndexByte("kmgt", text[i])+1)
+		text = text[:i]
+	}
+	n, err := strconv.ParseUint(text, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n << shift, nil
+}
+
+// localEndpoint reports whether endpoint is a docker daemon on this host,
+// whose files can be looked at directly
+func localEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "unix" {
+		return true
+	}
+	host := u.Hostname()
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// check returns why endpoint can not take a session of the profile, or nil
+func (c *PreflightConfig) check(endpoint string, client *docker.Client) error {
+	info, err := client.Info()
+	if err != nil {
+		return err
+	}
+
+	for _, label := range c.EndpointLabels {
+		found := false
+		for _, have := range info.Labels {
+			if have == label {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("endpoint label %s is missing", label)
+		}
+	}
+
+	if c.MinFreeMemory != "" {
+		need, err := parseSize(c.MinFreeMemory)
+		if err != nil {
+			return err
+		}
+		containers, err := client.ListContainers(docker.ListContainersOptions{Limit: -1})
+		if err != nil {
+			return err
+		}
+		_, used := containerUsage(client, containers)
+		var free uint64
+		if total := uint64(info.MemTotal); total > used {
+			free = total - used
+		}
+		if free < need {
+			return fmt.Errorf("%d MiB of memory is free, %s is needed", free>>20, c.MinFreeMemory)
+		}
+	}
+
+	// Files can only be looked for on endpoints of this host
+	if localEndpoint(endpoint) {
+		for _, path := range c.Files {
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("%s is missing", path)
+			}
+		}
+	}
+	return nil
+}
+
+// preflight rules out the endpoints failing the profile's pre-flight checks,
+// saying why for each
+func preflight(profileName string, profile *Profile, states []endpointState) {
+	if profile.Preflight.empty() {
+		return
+	}
+	for i := range states {
+		if states[i].Down {
+			continue
+		}
+		client, err := dockerClient(states[i].Endpoint)
+		if err == nil {
+			err = profile.Preflight.check(states[i].Endpoint, client)
+		}
+		if err != nil {
+			status(colorYellow, "Skipping %s, it fails the pre-flight checks of profile %s: %s", states[i].Endpoint, profileName, err)
+			states[i].Down = true
+		}
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"os/user"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"launchpad.net/goyaml"
+)
+
+// Profile describes the container a session gets. The top level of the
+// config is the default profile; named profiles only override what they set.
+type Profile struct {
+	Image         string   `yaml:"image,omitempty"`
+	User          string   `yaml:"user,omitempty"`
+	CanaryImage   string   `yaml:"canary_image,omitempty"`
+	CanaryPercent int      `yaml:"canary_percent,omitempty"`
+	Runtime       string   `yaml:"runtime,omitempty"`
+	Devices       []string `yaml:"devices,omitempty"`
+	DeviceRules   []string `yaml:"device_cgroup_rules,omitempty"`
+	Privileged    bool     `yaml:"privileged,omitempty"`
+	Groups        []string `yaml:"groups,omitempty"`
+	Shared        bool     `yaml:"shared,omitempty"`
+	Hostname      string   `yaml:"hostname,omitempty"`
+	MacAddress    string   `yaml:"mac_address,omitempty"`
+	GPUs          int      `yaml:"gpus,omitempty"`
+	Network       string   `yaml:"network,omitempty"`
+	Egress        []string `yaml:"egress,omitempty"`
+	Bandwidth     string   `yaml:"bandwidth,omitempty"`
+	NetworkImage  string   `yaml:"network_image,omitempty"`
+	Agent         string   `yaml:"agent,omitempty"`
+	Connect       []string `yaml:"connect,omitempty"`
+	Extends       string   `yaml:"extends,omitempty"`
+	Mixins        []string `yaml:"mixins,omitempty"`
+
+	Preflight PreflightConfig `yaml:"preflight,omitempty"`
+
+	// Raw JSON in the form of the docker API, for options not wrapped above
+	DockerConfig     string `yaml:"docker_config,omitempty"`
+	DockerHostConfig string `yaml:"docker_host_config,omitempty"`
+}
+
+// profileSettings holds the profiles as they are written in the config.
+// Profile can not tell a field set to false, zero or an empty list from one
+// left alone, so these are what a profile lays over the one below it.
+type profileSettings struct {
+	Profiles map[string]map[string]interface{} `yaml:"profiles,omitempty"`
+	Fleets   map[string]profileSettings        `yaml:"fleets,omitempty"`
+}
+
+// identity is what hostname and mac_address templates are expanded with
+type identity struct {
+	User string
+	Name string
+}
+
+func expand(text string, id identity) (string, error) {
+	t, err := template.New("").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, id); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// randomMAC returns a random locally administered unicast MAC address
+func randomMAC() string {
+	mac := make([]byte, 6)
+	rand.Read(mac)
+	mac[0] = mac[0]&0xfe | 0x02
+	return net.HardwareAddr(mac).String()
+}
+
+// identify sets the hostname and MAC address of a session container from
+// the profile's templates. A mac_address of random gets a new address for
+// every session.
+func (p *Profile) identify(dockerConfig *docker.Config, id identity) error {
+	var err error
+	if p.Hostname != "" {
+		if dockerConfig.Hostname, err = expand(p.Hostname, id); err != nil {
+			return err
+		}
+	}
+	switch p.MacAddress {
+	case "":
+	case "random":
+		dockerConfig.MacAddress = randomMAC()
+	default:
+		if dockerConfig.MacAddress, err = expand(p.MacAddress, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const labelProfile = "dockersshell.profile"
+const labelImage = "dockersshell.image"
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// profile returns the named profile laid over the default one
+func (c *Config) profile(name string) (*Profile, error) {
+	merged := c.Profile
+	if name == "" {
+		return &merged, nil
+	}
+
+	if err := c.layer(&merged, name, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	merged.Extends, merged.Mixins = "", nil
+	return &merged, nil
+}
+
+// layer lays the named profile over merged: first the profile it extends,
+// then its mixins in order and then what it sets itself, so that the last
+// one to set a field wins. path holds the profiles being laid, to refuse
+// cycles.
+func (c *Config) layer(merged *Profile, name string, path map[string]bool) error {
+	override, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("no profile named %s", name)
+	}
+	if path[name] {
+		return fmt.Errorf("profile %s extends itself", name)
+	}
+	path[name] = true
+	defer delete(path, name)
+
+	for _, parent := range append([]string{override.Extends}, override.Mixins...) {
+		if parent == "" {
+			continue
+		}
+		if err := c.layer(merged, parent, path); err != nil {
+			return err
+		}
+	}
+
+	// Unmarshalling the settings the profile has written over the default
+	// merges the two, including those turning something back off. A
+	// profile not read from the config only has the fields that survive
+	// marshalling, thanks to omitempty.
+	var settings interface{} = override
+	if written, ok := c.settings.Profiles[name]; ok {
+		settings = written
+	}
+	text, err := goyaml.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return goyaml.Unmarshal(text, merged)
+}
+
+// sessionProfile returns the profile a running session was created from
+func sessionProfile(config *Config, labels map[string]string) *Profile {
+	profile, err := config.profile(labels[labelProfile])
+	if err != nil {
+		return &config.Profile
+	}
+	return profile
+}
+
+// pickImage returns the image for a new session, sending canary_percent of
+// sessions to canary_image while a new image is rolled out
+func (p *Profile) pickImage() string {
+	if p.CanaryImage != "" && rand.Intn(100) < p.CanaryPercent {
+		return p.CanaryImage
+	}
+	return p.Image
+}
+
+// hostConfig returns the docker host configuration for containers of the
+// profile. It is passed on both create and start, as newer docker daemons
+// only honour it on create.
+func (p *Profile) hostConfig() docker.HostConfig {
+	host := docker.HostConfig{
+		PublishAllPorts:   true,
+		Runtime:           p.Runtime,
+		DeviceCgroupRules: p.DeviceRules,
+		Privileged:        p.Privileged,
+		NetworkMode:       p.Network,
+	}
+	for _, device := range p.Devices {
+		host.Devices = append(host.Devices, parseDevice(device))
+	}
+	return host
+}
+
+// raw lays the profile's docker_config and docker_host_config over what
+// dockersshell built for the create request. Environment given there is added
+// to that of the session. The fragments can give as much access to the
+// endpoint as privileged mode, so allowed limits such profiles to groups like
+// privileged ones; they still cannot set dockersshell labels, which would
+// change who owns the session, or privileged mode itself, so that it is only
+// ever set, warned about and audited through privileged.
+func (p *Profile) raw(dockerConfig *docker.Config, host *docker.HostConfig) error {
+	labels := map[string]string{}
+	for key, value := range dockerConfig.Labels {
+		labels[key] = value
+	}
+	env := dockerConfig.Env
+	dockerConfig.Env = nil
+
+	for _, fragment := range []struct {
+		name string
+		text string
+		into interface{}
+	}{{"docker_config", p.DockerConfig, dockerConfig}, {"docker_host_config", p.DockerHostConfig, host}} {
+		if fragment.text == "" {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(fragment.text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(fragment.into); err != nil {
+			return fmt.Errorf("invalid %s: %s", fragment.name, err)
+		}
+	}
+	dockerConfig.Env = append(env, dockerConfig.Env...)
+
+	for key, value := range dockerConfig.Labels {
+		if strings.HasPrefix(key, "dockersshell.") && labels[key] != value {
+			return fmt.Errorf("docker_config can not set the %s label", key)
+		}
+	}
+	if host.Privileged && !p.Privileged {
+		return fmt.Errorf("docker_host_config can not make sessions privileged, set privileged instead")
+	}
+	return nil
+}
+
+// allowed checks that user may create sessions from the profile. Profiles
+// with groups are limited to members of those groups, and privileged profiles
+// and those with raw docker config must name the groups allowed to use them.
+func (p *Profile) allowed(name string) error {
+	if len(p.Groups) == 0 {
+		if p.Privileged {
+			return fmt.Errorf("privileged profiles must be limited to groups")
+		}
+		if p.DockerConfig != "" || p.DockerHostConfig != "" {
+			return fmt.Errorf("profiles with docker_config or docker_host_config must be limited to groups")
+		}
+		return nil
+	}
+
+	groups, err := userGroups(name)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		for _, allowed := range p.Groups {
+			if group == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s is not in any of the groups %s", name, strings.Join(p.Groups, ", "))
+}
+
+// userGroups returns the names of the unix groups name is a member of
+func userGroups(name string) ([]string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []string
+	for _, id := range ids {
+		if group, err := user.LookupGroupId(id); err == nil {
+			groups = append(groups, group.Name)
+		}
+	}
+	return groups, nil
+}
+
+// parseDevice parses a device in the docker run --device form of
+// HOST[:CONTAINER[:PERMISSIONS]]. Docker adds the cgroup rule allowing the
+// container to use it.
+func parseDevice(device string) docker.Device {
+	parts := strings.SplitN(device, ":", 3)
+	d := docker.Device{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+	if len(parts) > 1 && parts[1] != "" {
+		d.PathInContainer = parts[1]
+	}
+	if len(parts) > 2 {
+		d.CgroupPermissions = parts[2]
+	}
+	return d
+}
+
+// images returns every image in use by the profiles
+func (c *Config) images() []string {
+	seen := map[string]bool{}
+	var images []string
+	add := func(p Profile) {
+		for _, image := range []string{p.Image, p.CanaryImage} {
+			if image != "" && !seen[image] {
+				seen[image] = true
+				images = append(images, image)
+			}
+		}
+	}
+
+	add(c.Profile)
+	for name := range c.Profiles {
+		if p, err := c.profile(name); err == nil {
+			add(*p)
+		}
+	}
+	return images
+}
+
+// runRollout reports which image every running session was created with,
+// so the progress of a canary rollout can be followed
+func runRollout(config *Config, user string, args []string) {
+	findCommand("rollout").flags().Parse(args)
+
+	counts := map[string]int{}
+	fmt.Printf("%-30s %-15s %-15s %s\n", "NAME", "OWNER", "PROFILE", "IMAGE")
+	for _, endpoint := range config.Endpoints {
+		client, err := dockerClient(endpoint)
+		if err != nil {
+			continue
+		}
+
+		containers, err := managedContainers(client)
+		if err != nil {
+			continue
+		}
+
+		for _, container := range containers {
+			poolLabels(&container)
+			labels := container.Labels
+			if labels[labelOwner] == "" {
+				continue
+			}
+
+			// Sessions created before profiles existed carry no image label
+			image := labels[labelImage]
+			if image == "" {
+				image = container.Image
+			}
+			counts[image]++
+			fmt.Printf("%-30s %-15s %-15s %s\n", labels[labelName], labels[labelOwner], labels[labelProfile], image)
+		}
+	}
+
+	fmt.Println()
+	for _, image := range config.images() {
+		fmt.Printf("%-60s %d\n", image, counts[image])
+	}
+}
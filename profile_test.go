@@ -0,0 +1,106 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"launchpad.net/goyaml"
+)
+
+const layerConfig = `
+image: ssh
+privileged: true
+profiles:
+  data:
+    image: ssh-data
+    groups: [data]
+    gpus: 2
+  gpu:
+    runtime: nvidia
+  isolated:
+    network: none
+  data-gpu:
+    extends: data
+    mixins: [gpu, isolated]
+    network: bridge
+  reset:
+    extends: data
+    privileged: false
+    gpus: 0
+    groups: []
+  loop:
+    extends: loop-back
+  loop-back:
+    mixins: [loop]
+`
+
+func TestProfileLayer(t *testing.T) {
+	var config Config
+	if err := goyaml.Unmarshal([]byte(layerConfig), &config); err != nil {
+		t.Fatal(err)
+	}
+	goyaml.Unmarshal([]byte(layerConfig), &config.settings)
+
+	tests := []struct {
+		name string
+		want Profile
+		err  string
+	}{
+		{"", Profile{Image: "ssh", Privileged: true}, ""},
+		{"data", Profile{Image: "ssh-data", Privileged: true, Groups: []string{"data"}, GPUs: 2}, ""},
+		{"data-gpu", Profile{Image: "ssh-data", Privileged: true, Groups: []string{"data"}, GPUs: 2, Runtime: "nvidia", Network: "bridge"}, ""},
+		{"reset", Profile{Image: "ssh-data", Groups: []string{}}, ""},
+		{"loop", Profile{}, "extends itself"},
+		{"missing", Profile{}, "no profile named missing"},
+	}
+	for _, test := range tests {
+		got, err := config.profile(test.name)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("profile(%q) error = %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("profile(%q) error = %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("profile(%q) = %+v, want %+v", test.name, *got, test.want)
+		}
+	}
+}
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		device string
+		want   docker.Device
+	}{
+		{"/dev/fuse", docker.Device{PathOnHost: "/dev/fuse", PathInContainer: "/dev/fuse", CgroupPermissions: "rwm"}},
+		{"/dev/sda:/dev/xvda", docker.Device{PathOnHost: "/dev/sda", PathInContainer: "/dev/xvda", CgroupPermissions: "rwm"}},
+		{"/dev/sda:/dev/xvda:r", docker.Device{PathOnHost: "/dev/sda", PathInContainer: "/dev/xvda", CgroupPermissions: "r"}},
+		{"/dev/sda::rw", docker.Device{PathOnHost: "/dev/sda", PathInContainer: "/dev/sda", CgroupPermissions: "rw"}},
+	}
+	for _, test := range tests {
+		if got := parseDevice(test.device); got != test.want {
+			t.Errorf("parseDevice(%q) = %+v, want %+v", test.device, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// promptHook is installed into /etc/profile.d and prefixes the bash prompt
+// with the time left until the session is cleaned up. sshd does not pass the
+// container environment on to login shells, so the expiry is baked in.
+const promptHook = `[ -n "$BASH_VERSION" ] || return 0
+DSSHELL_EXPIRES=%d
+export DSSHELL_EXPIRES
+_dsshell_prompt() {
+    left=$(( DSSHELL_EXPIRES - $(date +%%s) ))
+    [ "$left" -lt 0 ] && left=0
+    DSSHELL_REMAINING=$(printf '%%dh%%02dm' $((left / 3600)) $((left %% 3600 / 60)))
+    case "$PS1" in
+        *DSSHELL_REMAINING*) ;;
+        *) PS1='[$DSSHELL_REMAINING] '"$PS1" ;;
+    esac
+}
+PROMPT_COMMAND="_dsshell_prompt${PROMPT_COMMAND:+; $PROMPT_COMMAND}"
+`
+
+// installPromptHook writes the expiry prompt hook into the container
+func installPromptHook(client *docker.Client, id string, expires int64) {
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		Container:    id,
+		User:         "root",
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          []string{"sh", "-c", `printf '%s' "$1" > /etc/profile.d/dockersshell-expiry.sh`, "sh", fmt.Sprintf(promptHook, expires)},
+	})
+	if err != nil {
+		log.Print(msg("Unable to install prompt hook: %s\n", err))
+		return
+	}
+
+	opts := docker.StartExecOptions{OutputStream: ioutil.Discard, ErrorStream: ioutil.Discard}
+	if err := client.StartExec(exec.ID, opts); err != nil {
+		log.Print(msg("Unable to install prompt hook: %s\n", err))
+	}
+}
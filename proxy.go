@@ -0,0 +1,259 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// proxyFor returns the proxy to reach address through for sessions on
+// endpoint: the endpoint's proxy setting, otherwise HTTPS_PROXY and friends
+// from the environment, or nil to connect directly
+func proxyFor(endpoint string, address string) *url.URL {
+	if settings, ok := endpointConfigs[endpoint]; ok && settings.Proxy != "" {
+		u, err := url.Parse(settings.Proxy)
+		if err != nil {
+			log.Fatal(msg("Unable to parse proxy URL: %s\n", err))
+		}
+		return u
+	}
+
+	u, _ := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: address}})
+	return u
+}
+
+// dialProxy opens a TCP connection to address through an HTTP CONNECT or
+// SOCKS5 proxy, or directly when proxy is nil
+func dialProxy(proxy *url.URL, address string, timeout time.Duration) (net.Conn, error) {
+	if proxy == nil {
+		return net.DialTimeout("tcp", address, timeout)
+	}
+
+	var conn net.Conn
+	var err error
+	switch proxy.Scheme {
+	case "https":
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", proxy.Host, nil)
+	case "http", "socks5", "socks5h":
+		conn, err = net.DialTimeout("tcp", proxy.Host, timeout)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxy.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if strings.HasPrefix(proxy.Scheme, "socks5") {
+		err = socks5Connect(conn, proxy.User, address)
+	} else {
+		err = httpConnect(conn, proxy.User, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func httpConnect(conn net.Conn, user *url.Userinfo, address string) error {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return err
+	}
+
+	// The proxy sends nothing after its response until the tunnel is used,
+	// so reading through a buffer does not swallow session data
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy refused connection: %s", resp.Status)
+	}
+	return nil
+}
+
+// socks5Connect performs a SOCKS5 handshake (RFC 1928), with username and
+// password authentication (RFC 1929) when the proxy URL has credentials
+func socks5Connect(conn net.Conn, user *url.Userinfo, address string) error {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return err
+	}
+
+	method := byte(0x00)
+	if user != nil {
+		method = 0x02
+	}
+	if _, err := conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != method {
+		return fmt.Errorf("socks proxy refused authentication method")
+	}
+
+	if user != nil {
+		password, _ := user.Password()
+		auth := []byte{0x01, byte(len(user.Username()))}
+		auth = append(auth, user.Username()...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("socks proxy authentication failed")
+		}
+	}
+
+	req := []byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}
+	req = append(req, host...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("socks proxy refused connection: code %d", header[1])
+	}
+
+	// Skip the bound address that follows
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// proxyCommand returns the ssh ProxyCommand reaching sessions on endpoint
+// through its proxy, or an empty string when no proxy is needed
+func proxyCommand(endpoint string, host string, port string) string {
+	if proxyFor(endpoint, net.JoinHostPort(host, port)) == nil {
+		return ""
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		self = "dockersshell"
+	}
+	return fmt.Sprintf("'%s' __proxy '%s' %%h %%p", self, endpoint)
+}
+
+// runProxy is used as an ssh ProxyCommand, connecting stdin and stdout to a
+// session through the proxy of its endpoint
+func runProxy(config *Config, user string, args []string) {
+	if len(args) != 3 {
+		log.Fatal(msg("Usage: dockersshell __proxy ENDPOINT HOST PORT"))
+	}
+
+	pipe(args[0], args[1], args[2])
+}
+
+// pipe connects stdin and stdout to host:port on endpoint
+func pipe(endpoint string, host string, port string) {
+	address := net.JoinHostPort(host, port)
+	conn, err := dialProxy(proxyFor(endpoint, address), address, 30*time.Second)
+	if err != nil {
+		log.Fatal(msg("Unable to connect to %s: %s\n", address, err))
+	}
+
+	go func() {
+		io.Copy(conn, os.Stdin)
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+	}()
+	io.Copy(os.Stdout, conn)
+}
+
+// proxySession is -proxy-command: it finds the named session, creating it
+// when there is none, and connects stdin and stdout to its sshd so ssh can
+// reach sessions by name. The session's host keys are recorded under its
+// name. Stdin belongs to ssh, so nothing can be prompted for.
+func proxySession(config *Config, user string, opts sessionOptions) {
+	nonInteractive = true
+
+	var endpoint, id string
+	var client *docker.Client
+	if s := findSession(config.Endpoints, user, opts.Name); s != nil {
+		endpoint, client, id = s.Endpoint, s.Client, s.Container.ID
+	} else {
+		var container *docker.Container
+		endpoint, client, container = createSession(config, user, opts)
+		saveLastOptions(opts)
+		journalEnd(dockerName(container))
+		id = container.ID
+	}
+
+	host, port := sessionAddress(endpoint, client, id)
+	wait(endpoint, host, port)
+	keys, err := readHostKeys(client, id)
+	if err == nil {
+		err = recordHostKeys(opts.Name, "", keys)
+	}
+	if err != nil {
+		log.Print(msg("Unable to publish host keys, host key will not be verified: %s\n", err))
+	}
+
+	stopDeadline()
+	pipe(endpoint, host, port)
+}
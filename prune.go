@@ -0,0 +1,174 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// forgetSession removes the ssh config and known hosts entries of a session
+// that has ended, so ssh does not offer them or trip over a reused port
+func forgetSession(name string, host string, port string) {
+	uncacheSession(name)
+	if err := removeSSHConfigEntry(name); err != nil {
+		fmt.Print(msg("Unable to update ssh config: %s\n", err))
+	}
+	err := forgetHostKeys(name, "")
+	if err == nil && port != "" {
+		err = forgetHostKeys(host, port)
+	}
+	if err != nil {
+		fmt.Print(msg("Unable to update known hosts: %s\n", err))
+	}
+}
+
+// sshConfigEntries returns the HostName of every session in the managed ssh
+// config file, by session name
+func sshConfigEntries() (map[string]string, error) {
+	text, err := ioutil.ReadFile(sshIncludePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	entries := map[string]string{}
+	name := ""
+	for _, line := range strings.Split(string(text), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "# BEGIN dockersshell "):
+			name = strings.TrimPrefix(line, "# BEGIN dockersshell ")
+			entries[name] = ""
+		case strings.HasPrefix(line, "# END dockersshell "):
+			name = ""
+		case name != "" && len(fields) == 2 && fields[0] == "HostName":
+			entries[name] = fields[1]
+		}
+	}
+	return entries, nil
+}
+
+// knownHostsAddresses returns the host and port of every entry in the
+// dockersshell known hosts file. Entries recorded by -proxy-command are
+// under the session name, with no port.
+func knownHostsAddresses() ([][2]string, error) {
+	text, err := ioutil.ReadFile(knownHostsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var addresses [][2]string
+	for _, line := range strings.Split(string(text), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		if !strings.HasPrefix(fields[0], "[") {
+			addresses = append(addresses, [2]string{fields[0], ""})
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(fields[0], "["), "]:", 2)
+		if len(parts) == 2 {
+			addresses = append(addresses, [2]string{parts[0], parts[1]})
+		}
+	}
+	return addresses, nil
+}
+
+// runPrune reconciles the ssh config and known hosts files with the sessions
+// that are running. Entries on endpoints that cannot be reached are kept, as
+// their sessions may still be there.
+func runPrune(config *Config, user string, args []string) {
+	fs := findCommand("prune").flags()
+	dryRun := fs.Bool("dry-run", false, "Only print what would be removed")
+	fs.Parse(args)
+	requireUser(user)
+
+	live := map[string]bool{}
+	addresses := map[string]bool{}
+	unreachable := map[string]bool{}
+	for _, endpoint := range config.Endpoints {
+		host := connectHost(endpoint)
+		client, err := dockerClient(endpoint)
+		var containers []docker.APIContainers
+		if err == nil {
+			containers, err = managedContainers(client)
+		}
+		if err != nil {
+			log.Print(msg("Unable to list sessions on %s, keeping its entries: %s\n", endpoint, err))
+			unreachable[host] = true
+			continue
+		}
+
+		for _, container := range containers {
+			poolLabels(&container)
+			if container.Labels[labelOwner] == user {
+				live[container.Labels[labelName]] = true
+			}
+			// Shared sessions are reached through keys recorded by
+			// every user, so their ports are kept whoever owns them
+			for _, port := range container.Ports {
+				if port.PrivatePort == 22 {
+					addresses[host+":"+strconv.FormatInt(port.PublicPort, 10)] = true
+				}
+			}
+		}
+	}
+
+	entries, err := sshConfigEntries()
+	if err != nil {
+		log.Fatal(msg("Unable to read ssh config: %s\n", err))
+	}
+	for name, host := range entries {
+		if live[name] || unreachable[host] {
+			continue
+		}
+		fmt.Print(msg("Removing ssh config entry %s\n", name))
+		if !*dryRun {
+			if err := removeSSHConfigEntry(name); err != nil {
+				log.Print(msg("Unable to update ssh config: %s\n", err))
+			}
+		}
+	}
+
+	known, err := knownHostsAddresses()
+	if err != nil {
+		log.Fatal(msg("Unable to read known hosts: %s\n", err))
+	}
+	for _, address := range known {
+		host, port := address[0], address[1]
+		if port == "" && (live[host] || len(unreachable) != 0) {
+			continue
+		}
+		if port != "" && (addresses[host+":"+port] || unreachable[host]) {
+			continue
+		}
+		fmt.Print(msg("Removing known hosts entries for %s\n", knownHostsPattern(host, port)))
+		if !*dryRun {
+			if err := forgetHostKeys(host, port); err != nil {
+				log.Print(msg("Unable to update known hosts: %s\n", err))
+			}
+		}
+	}
+}
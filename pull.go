@@ -0,0 +1,56 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// pullImages pulls or refreshes the images of every profile on every
+// endpoint in parallel, so the first session of the day does not wait for a
+// pull
+func pullImages(config *Config) {
+	var wg sync.WaitGroup
+	for _, endpoint := range config.Endpoints {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			client, err := dockerClient(endpoint)
+			if err != nil {
+				log.Print(msg("Unable to communicate with %s: %s\n", endpoint, err))
+				return
+			}
+
+			for _, image := range config.images() {
+				repository, tag := docker.ParseRepositoryTag(image)
+				if tag == "" {
+					tag = "latest"
+				}
+
+				opts := docker.PullImageOptions{Repository: repository, Tag: tag}
+				if err := client.PullImage(opts, docker.AuthConfiguration{}); err != nil {
+					log.Print(msg("Unable to pull %s on %s: %s\n", image, endpoint, err))
+					continue
+				}
+				status(colorGreen, "Pulled %s on %s", image, endpoint)
+			}
+		}(endpoint)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,126 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyTimeout bounds how long an endpoint may take to accept a connection
+// before its region is considered unreachable
+const latencyTimeout = 2 * time.Second
+
+// addRegionEndpoints makes the endpoints of every region known to the
+// commands that look through all endpoints
+func addRegionEndpoints(config *Config) {
+	seen := map[string]bool{}
+	for _, endpoint := range config.Endpoints {
+		seen[endpoint] = true
+	}
+
+	var regions []string
+	for region := range config.Regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	for _, region := range regions {
+		for _, endpoint := range config.Regions[region] {
+			if !seen[endpoint] {
+				seen[endpoint] = true
+				config.Endpoints = append(config.Endpoints, endpoint)
+			}
+		}
+	}
+}
+
+// latency returns how long it takes to open a TCP connection to an endpoint's
+// docker API
+func latency(endpoint string) (time.Duration, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	conn, err := dialProxy(proxyFor(endpoint, u.Host), u.Host, latencyTimeout)
+	if err != nil {
+		return 0, err
+	}
+	conn.Close()
+	return time.Since(start), nil
+}
+
+// regionsByLatency returns the reachable regions, closest first. A region's
+// latency is that of its fastest endpoint.
+func regionsByLatency(config *Config) []string {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	best := map[string]time.Duration{}
+	for region, endpoints := range config.Regions {
+		for _, endpoint := range endpoints {
+			wg.Add(1)
+			go func(region string, endpoint string) {
+				defer wg.Done()
+				d, err := latency(endpoint)
+				if err != nil {
+					return
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+				if current, ok := best[region]; !ok || d < current {
+					best[region] = d
+				}
+			}(region, endpoint)
+		}
+	}
+	wg.Wait()
+
+	var regions []string
+	for region := range best {
+		regions = append(regions, region)
+	}
+	sort.Slice(regions, func(i, j int) bool { return best[regions[i]] < best[regions[j]] })
+	return regions
+}
+
+// candidateEndpoints returns the groups of endpoints to try placing a
+// session on, in order. Without regions that is every endpoint; with them it
+// is the requested region, or every reachable region by latency.
+func candidateEndpoints(config *Config, region string) [][]string {
+	if len(config.Regions) == 0 {
+		return [][]string{config.Endpoints}
+	}
+
+	if region != "" {
+		endpoints, ok := config.Regions[region]
+		if !ok {
+			log.Fatal(msg("No region named %s", region))
+		}
+		return [][]string{endpoints}
+	}
+
+	var groups [][]string
+	for _, region := range regionsByLatency(config) {
+		groups = append(groups, config.Regions[region])
+	}
+	return groups
+}
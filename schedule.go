@@ -0,0 +1,140 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+
+	"launchpad.net/goyaml"
+)
+
+// maxContainers is the number of running containers at which an endpoint is
+// no longer considered for new sessions
+const maxContainers = 1024
+
+type endpointState struct {
+	Endpoint   string   `yaml:"endpoint"`
+	Containers int      `yaml:"containers"`
+	Down       bool     `yaml:"down,omitempty"`
+	FreeGPUs   []string `yaml:"free_gpus,omitempty"`
+	Cost       float64  `yaml:"cost,omitempty"`
+	Usage      int      `yaml:"usage,omitempty"`
+}
+
+// schedule picks the endpoint for a new session from the observed endpoint
+// states, preferring the cheapest endpoints, and among those the first idle
+// endpoint and otherwise the least loaded one, as measured by the placement
+// policy. It returns an empty string
+// when no endpoint is acceptable.
+func schedule(states []endpointState) string {
+	cheapest := -1.0
+	for _, state := range states {
+		if !state.Down && state.Containers < maxContainers && (cheapest < 0 || state.Cost < cheapest) {
+			cheapest = state.Cost
+		}
+	}
+
+	var endpoint string
+	var smallest int
+	for _, state := range states {
+		if state.Down || state.Cost != cheapest || state.Containers >= maxContainers {
+			continue
+		}
+		if state.load() == 0 {
+			return state.Endpoint
+		} else if endpoint == "" || state.load() < smallest {
+			endpoint = state.Endpoint
+			smallest = state.load()
+		}
+	}
+	return endpoint
+}
+
+// affinityRank orders endpoints for user by rendezvous hashing: every user
+// gets a stable preference order over the endpoints. Adding an endpoint only
+// moves the users that now prefer it, and removing or draining one only
+// moves its own users, to their next preference.
+func affinityRank(endpoints []string, user string) []string {
+	weight := func(endpoint string) uint64 {
+		sum := sha1.Sum([]byte(user + "\x00" + endpoint))
+		return binary.BigEndian.Uint64(sum[:8])
+	}
+
+	ranked := append([]string(nil), endpoints...)
+	sort.Slice(ranked, func(i, j int) bool { return weight(ranked[i]) > weight(ranked[j]) })
+	return ranked
+}
+
+// affinity picks the most preferred acceptable endpoint for user, so that
+// their images, volumes and snapshots stay on one endpoint
+func affinity(states []endpointState, user string) string {
+	acceptable := map[string]bool{}
+	var endpoints []string
+	for _, state := range states {
+		endpoints = append(endpoints, state.Endpoint)
+		acceptable[state.Endpoint] = !state.Down && state.Containers < maxContainers
+	}
+
+	for _, endpoint := range affinityRank(endpoints, user) {
+		if acceptable[endpoint] {
+			return endpoint
+		}
+	}
+	return ""
+}
+
+// place picks the endpoint for a new session of user, by affinity when it
+// is enabled and by load otherwise
+func place(config *Config, states []endpointState, user string) string {
+	if config.Affinity {
+		return affinity(states, user)
+	}
+	return schedule(states)
+}
+
+// simulate reads a YAML list of endpoint states from path and prints the
+// placement decision, without talking to any endpoint. With a user the
+// decision is made by affinity.
+func simulate(path string, user string) {
+	if path == "" {
+		log.Fatal(msg("Usage: dockersshell simulate <states.yaml>"))
+	}
+
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(msg("Unable to read states: %s\n", err))
+	}
+
+	var states []endpointState
+	if err := goyaml.Unmarshal(text, &states); err != nil {
+		log.Fatal(msg("Unable to parse states: %s\n", err))
+	}
+
+	endpoint := schedule(states)
+	if user != "" {
+		endpoint = affinity(states, user)
+	}
+	if endpoint == "" {
+		fmt.Println("No acceptable endpoints found")
+		return
+	}
+	fmt.Println(endpoint)
+}
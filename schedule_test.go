@@ -0,0 +1,71 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import "testing"
+
+func TestSchedule(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []endpointState
+		want   string
+	}{
+		{"none", nil, ""},
+		{"first idle", []endpointState{{Endpoint: "a", Containers: 2}, {Endpoint: "b"}, {Endpoint: "c"}}, "b"},
+		{"least loaded", []endpointState{{Endpoint: "a", Containers: 3}, {Endpoint: "b", Containers: 1}, {Endpoint: "c", Containers: 2}}, "b"},
+		{"down", []endpointState{{Endpoint: "a", Down: true}, {Endpoint: "b", Containers: 5}}, "b"},
+		{"full", []endpointState{{Endpoint: "a", Containers: maxContainers}, {Endpoint: "b", Containers: 7}}, "b"},
+		{"cheapest", []endpointState{{Endpoint: "a", Cost: 2}, {Endpoint: "b", Cost: 1, Containers: 9}}, "b"},
+		{"cheapest down", []endpointState{{Endpoint: "a", Cost: 2, Containers: 3}, {Endpoint: "b", Cost: 1, Down: true}}, "a"},
+		{"all down", []endpointState{{Endpoint: "a", Down: true}, {Endpoint: "b", Containers: maxContainers}}, ""},
+	}
+	for _, test := range tests {
+		if got := schedule(test.states); got != test.want {
+			t.Errorf("%s: schedule = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAffinity(t *testing.T) {
+	endpoints := []string{"a", "b", "c", "d"}
+	rank := affinityRank(endpoints, "alice")
+	if len(rank) != len(endpoints) {
+		t.Fatalf("affinityRank = %v, want every endpoint", rank)
+	}
+	if again := affinityRank([]string{"d", "c", "b", "a"}, "alice"); again[0] != rank[0] || again[3] != rank[3] {
+		t.Errorf("affinityRank depends on the order of the endpoints: %v and %v", rank, again)
+	}
+
+	tests := []struct {
+		name string
+		down map[string]bool
+		want string
+	}{
+		{"all up", nil, rank[0]},
+		{"first down", map[string]bool{rank[0]: true}, rank[1]},
+		{"first two down", map[string]bool{rank[0]: true, rank[1]: true}, rank[2]},
+		{"all down", map[string]bool{"a": true, "b": true, "c": true, "d": true}, ""},
+	}
+	for _, test := range tests {
+		var states []endpointState
+		for _, endpoint := range endpoints {
+			states = append(states, endpointState{Endpoint: endpoint, Down: test.down[endpoint]})
+		}
+		if got := affinity(states, "alice"); got != test.want {
+			t.Errorf("%s: affinity = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"launchpad.net/goyaml"
+)
+
+// scheduledSession is a session requested for a future time window. It is
+// created when the window opens and removed when it closes, by clean.
+type scheduledSession struct {
+	Owner    string `yaml:"owner"`
+	Name     string `yaml:"name"`
+	Profile  string `yaml:"profile,omitempty"`
+	Region   string `yaml:"region,omitempty"`
+	Start    int64  `yaml:"start"`
+	Duration int64  `yaml:"duration"`
+	Every    int64  `yaml:"every,omitempty"`
+	Reason   string `yaml:"reason,omitempty"`
+	Ticket   string `yaml:"ticket,omitempty"`
+}
+
+// scheduleDir holds one file per scheduled session. Every user writes their
+// own, so it is one of the userDirs set up by root.
+func scheduleDir(config *Config) string {
+	return filepath.Join(config.stateDir(), "schedules")
+}
+
+func schedulePath(config *Config, owner string, name string) string {
+	return filepath.Join(scheduleDir(config), owner+"."+name+".yaml")
+}
+
+// save writes the scheduled session for its owner. Only the owner writes it;
+// clean keeps its progress through the windows in the schedule state.
+func (s *scheduledSession) save(path string) error {
+	text, err := goyaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := openNoFollow(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(text)
+	return err
+}
+
+// loadScheduled reads a scheduled session, refusing symlinks and files not
+// written by their owner, as anyone can write to the schedule directory
+func loadScheduled(path string) (*scheduledSession, error) {
+	f, err := openNoFollow(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	text, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var s scheduledSession
+	if err := goyaml.Unmarshal(text, &s); err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFileOwner(path, info, s.Owner); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// scheduleState is how far clean got with a scheduled session: the window it
+// is at, whether that one is running and how many have run. It is kept apart
+// from the requests, which belong to their users.
+type scheduleState struct {
+	Requested int64 `yaml:"requested"`
+	Start     int64 `yaml:"start"`
+	Started   bool  `yaml:"started,omitempty"`
+	Windows   int   `yaml:"windows,omitempty"`
+}
+
+func scheduleStatePath(config *Config) string {
+	return filepath.Join(config.stateDir(), "schedules.yaml")
+}
+
+func loadScheduleStates(config *Config) map[string]scheduleState {
+	states := map[string]scheduleState{}
+	if text, err := ioutil.ReadFile(scheduleStatePath(config)); err == nil {
+		goyaml.Unmarshal(text, &states)
+	}
+	return states
+}
+
+func saveScheduleStates(config *Config, states map[string]scheduleState) error {
+	text, err := goyaml.Marshal(states)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(scheduleStatePath(config), text, 0644)
+}
+
+// state returns where s is at, starting over when it was scheduled anew
+func (s *scheduledSession) state(states map[string]scheduleState, key string) scheduleState {
+	state, ok := states[key]
+	if !ok || state.Requested != s.Start {
+		state = scheduleState{Requested: s.Start, Start: s.Start}
+	}
+	return state
+}
+
+// scheduleRepeats is how many windows a recurring scheduled session gets
+// before it has to be scheduled again, with the second factor
+func (c *Config) scheduleRepeats() int {
+	if c.ScheduleRepeats == 0 {
+		return 10
+	}
+	return c.ScheduleRepeats
+}
+
+// parseStart parses a window start given as RFC 3339 or local time
+func parseStart(text string) (time.Time, error) {
+	if start, err := time.Parse(time.RFC3339, text); err == nil {
+		return start, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04", text, time.Local)
+}
+
+// runSchedules starts the scheduled sessions whose window has opened and
+// removes those whose window has closed. Each is handled by a separate
+// process, as creating and removing sessions exits on failure.
+func runSchedules(config *Config) {
+	if err := checkUserDir(scheduleDir(config)); err != nil {
+		if _, statErr := os.Lstat(scheduleDir(config)); !os.IsNotExist(statErr) {
+			log.Print(msg("Unable to run scheduled sessions: %s\n", err))
+		}
+		return
+	}
+
+	states := loadScheduleStates(config)
+	paths, _ := filepath.Glob(filepath.Join(scheduleDir(config), "*.yaml"))
+	seen := map[string]bool{}
+	now := time.Now().Unix()
+	for _, path := range paths {
+		key := filepath.Base(path)
+		s, err := loadScheduled(path)
+		if err != nil {
+			log.Print(msg("Unable to read scheduled session: %s\n", err))
+			continue
+		}
+		seen[key] = true
+		state := s.state(states, key)
+
+		var action string
+		switch {
+		case state.Started && now >= state.Start+s.Duration:
+			action = "stop"
+		case !state.Started && now >= state.Start+s.Duration:
+			// The window passed while nothing ran, so it is skipped
+			action = "skip"
+		case !state.Started && now >= state.Start:
+			action = "start"
+		default:
+			continue
+		}
+
+		cmd := exec.Command(os.Args[0], "__scheduled", action, path, strconv.FormatInt(state.Start, 10))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			log.Print(msg("Unable to %s scheduled session %s of %s: %s\n", action, s.Name, s.Owner, err))
+			continue
+		}
+
+		if action == "start" {
+			state.Started = true
+		} else if !s.nextWindow(&state, config.scheduleRepeats()) {
+			// Removing the request only unlinks it, even if it was replaced
+			os.Remove(path)
+			delete(seen, key)
+			continue
+		}
+		states[key] = state
+	}
+
+	for key := range states {
+		if !seen[key] {
+			delete(states, key)
+		}
+	}
+	if err := saveScheduleStates(config, states); err != nil {
+		log.Print(msg("Unable to save scheduled sessions: %s\n", err))
+	}
+}
+
+// nextWindow moves a recurring scheduled session to its next window that has
+// not yet closed. It returns false for one-off sessions and those that have
+// had their windows.
+func (s *scheduledSession) nextWindow(state *scheduleState, repeats int) bool {
+	state.Windows++
+	if s.Every == 0 || state.Windows >= repeats {
+		return false
+	}
+	state.Started = false
+	for state.Start+s.Duration <= time.Now().Unix() {
+		state.Start += s.Every
+	}
+	return true
+}
+
+// runScheduledAction starts or stops one scheduled session for runSchedules,
+// for the window starting at the given time
+func runScheduledAction(config *Config, user string, args []string) {
+	if len(args) != 3 {
+		os.Exit(2)
+	}
+	s, err := loadScheduled(args[1])
+	if err != nil {
+		log.Fatal(msg("Unable to read scheduled session: %s\n", err))
+	}
+	start, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "start":
+		opts := sessionOptions{Name: s.Name, Profile: s.Profile, Region: s.Region, Reason: s.Reason, Ticket: s.Ticket, Scheduled: true}
+		endpoint, client, container := createSession(config, s.Owner, opts)
+		journalEnd(dockerName(container))
+		host, port := sessionAddress(endpoint, client, container.ID)
+
+		status(colorGreen, "Started scheduled session %s of %s", s.Name, s.Owner)
+		if config.SMTP.Server != "" {
+			end := time.Unix(start+s.Duration, 0)
+			body := msg("Your scheduled dockersshell session %s is ready at %s port %s until %s.", s.Name, host, port, end.Format(time.RFC1123))
+			if err := sendMail(&config.SMTP, s.Owner, msg("dockersshell session %s is ready", s.Name), body); err != nil {
+				log.Print(msg("Unable to notify %s: %s\n", s.Owner, err))
+			}
+		}
+	case "stop":
+		if session := findSession(config.Endpoints, s.Owner, s.Name); session != nil {
+			destroySession(config, session.Endpoint, session.Client, session.Container.ID, s.Name, -1)
+		}
+	case "skip":
+	}
+}
+
+// runSchedule schedules, lists and cancels sessions in future time windows
+func runSchedule(config *Config, user string, args []string) {
+	var opts sessionOptions
+	fs := findCommand("schedule").flags()
+	fs.StringVar(&opts.Name, "name", "", "Name of the session")
+	fs.StringVar(&opts.Profile, "profile", "", "Profile to create the session from")
+	fs.StringVar(&opts.Region, "region", "", "Region to create the session in, instead of the closest")
+	fs.StringVar(&opts.Reason, "reason", "", "Why you need the session, when a justification is required")
+	fs.StringVar(&opts.Ticket, "ticket", "", "Ticket the session is for, when a justification is required")
+	every := fs.Duration("every", 0, "Repeat the window at this interval")
+	list := fs.Bool("list", false, "List your scheduled sessions")
+	cancel := fs.Bool("cancel", false, "Cancel the scheduled session named with -name")
+	fs.Parse(args)
+
+	if *list {
+		states := loadScheduleStates(config)
+		fmt.Printf("%-30s %-20s %-12s %s\n", "NAME", "START", "DURATION", "EVERY")
+		paths, _ := filepath.Glob(schedulePath(config, user, "*"))
+		for _, path := range paths {
+			s, err := loadScheduled(path)
+			if err != nil || s.Owner != user {
+				continue
+			}
+			state := s.state(states, filepath.Base(path))
+			var repeat string
+			if s.Every != 0 {
+				repeat = msg("%s, %d of %d windows left", time.Duration(s.Every)*time.Second, config.scheduleRepeats()-state.Windows, config.scheduleRepeats())
+			}
+			start := time.Unix(state.Start, 0).Format("2006-01-02 15:04")
+			fmt.Printf("%-30s %-20s %-12s %s\n", s.Name, start, time.Duration(s.Duration)*time.Second, repeat)
+		}
+		return
+	}
+
+	if opts.Name == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := schedulePath(config, user, opts.Name)
+
+	if *cancel {
+		if err := os.Remove(path); err != nil {
+			log.Fatal(msg("Unable to cancel scheduled session: %s\n", err))
+		}
+		return
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	start, err := parseStart(fs.Arg(0))
+	if err != nil {
+		log.Fatal(msg("Unable to parse start time: %s\n", err))
+	}
+	duration, err := time.ParseDuration(fs.Arg(1))
+	if err != nil {
+		log.Fatal(msg("Unable to parse duration: %s\n", err))
+	}
+	if config.MaxAge != 0 && duration > time.Duration(config.MaxAge)*time.Second {
+		log.Fatal(msg("Sessions cannot last longer than %s", time.Duration(config.MaxAge)*time.Second))
+	}
+	if *every != 0 && *every < duration {
+		log.Fatal(msg("Windows cannot repeat more often than they last"))
+	}
+
+	// The session is created unattended, so its profile, justification and
+	// second factor are checked now
+	if err := checkAssignedProfile(&opts.Profile); err != nil {
+		log.Fatal(msg("Unable to use profile %s: %s\n", opts.Profile, err))
+	}
+	profile, err := config.profile(opts.Profile)
+	if err != nil {
+		log.Fatal(msg("Unable to use profile: %s\n", err))
+	}
+	if err := profile.allowed(user); err != nil {
+		log.Fatal(msg("Unable to use profile %s: %s\n", opts.Profile, err))
+	}
+	if err := checkPolicies(config, user, opts.Profile, start); err != nil {
+		log.Fatal(msg("Unable to schedule session: %s\n", err))
+	}
+	justify(config, user, &opts)
+	verifySecondFactor(config, user)
+
+	s := scheduledSession{
+		Owner:    user,
+		Name:     opts.Name,
+		Profile:  opts.Profile,
+		Region:   opts.Region,
+		Start:    start.Unix(),
+		Duration: int64(duration / time.Second),
+		Every:    int64(*every / time.Second),
+		Reason:   opts.Reason,
+		Ticket:   opts.Ticket,
+	}
+	if err := checkUserDir(scheduleDir(config)); err != nil {
+		log.Fatal(msg("Unable to schedule session: %s\n", err))
+	}
+	if err := s.save(path); err != nil {
+		log.Fatal(msg("Unable to schedule session: %s\n", err))
+	}
+	status(colorGreen, "Scheduled %s for %s", opts.Name, start.Format(time.RFC1123))
+}
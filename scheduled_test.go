@@ -0,0 +1,69 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStart(t *testing.T) {
+	tests := []struct {
+		text string
+		want time.Time
+		ok   bool
+	}{
+		{"2026-11-02T08:45:00Z", time.Date(2026, 11, 2, 8, 45, 0, 0, time.UTC), true},
+		{"2026-11-02T08:45:00+02:00", time.Date(2026, 11, 2, 6, 45, 0, 0, time.UTC), true},
+		{"2026-11-02 09:00", time.Date(2026, 11, 2, 9, 0, 0, 0, time.Local), true},
+		{"2026-11-02", time.Time{}, false},
+		{"tomorrow", time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, err := parseStart(test.text)
+		if (err == nil) != test.ok || test.ok && !got.Equal(test.want) {
+			t.Errorf("parseStart(%q) = %v, %v, want %v", test.text, got, err, test.want)
+		}
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name    string
+		every   int64
+		start   int64
+		windows int
+		more    bool
+		next    int64
+	}{
+		{"one-off", 0, now - 100, 0, false, now - 100},
+		{"next day", 86400, now - 100, 0, true, now - 100 + 86400},
+		{"missed windows", 60, now - 1000, 0, true, now - 1000 + 16*60},
+		{"last window", 86400, now - 100, 9, false, now - 100},
+	}
+	for _, test := range tests {
+		s := &scheduledSession{Duration: 50, Every: test.every}
+		state := scheduleState{Start: test.start, Started: true, Windows: test.windows}
+		more := s.nextWindow(&state, 10)
+		if more != test.more || state.Windows != test.windows+1 {
+			t.Errorf("%s: nextWindow = %v with %d windows, want %v with %d", test.name, more, state.Windows, test.more, test.windows+1)
+		}
+		if more && (state.Start != test.next || state.Started) {
+			t.Errorf("%s: next window starts at %d, started %v, want %d", test.name, state.Start, state.Started, test.next)
+		}
+	}
+}
@@ -0,0 +1,591 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	labelManaged = "dockersshell.managed"
+	labelOwner   = "dockersshell.owner"
+	labelName    = "dockersshell.name"
+)
+
+type sessionOptions struct {
+	Name      string
+	Profile   string
+	Region    string
+	SSHConfig bool
+	Labels    map[string]string
+	Tags      tagFlags
+	Reason    string
+	Ticket    string
+	Timeout   time.Duration
+
+	// Image and Env recreate a session from an exported definition
+	Image string
+	Env   []string
+
+	// Scheduled sessions are created unattended, with the second factor
+	// verified when they were scheduled
+	Scheduled bool
+}
+
+// userID returns user as lowercase letters and digits, safe in container
+// names, hostnames and DNS labels. Names that had to change, or lose the dash
+// the container name splits on, get a hash of the original so that they stay
+// distinct; the labels keep the original.
+func userID(user string) string {
+	var id strings.Builder
+	for _, r := range strings.ToLower(user) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			id.WriteRune(r)
+		}
+	}
+	if id.String() == user {
+		return user
+	}
+	short := id.String()
+	if len(short) > 32 {
+		short = short[:32]
+	}
+	return fmt.Sprintf("%sx%x", short, sha1.Sum([]byte(user)))[:len(short)+9]
+}
+
+// dnsLabel returns s as a DNS label, with a hash of the original when it had
+// to change
+func dnsLabel(s string) string {
+	var label strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' {
+			label.WriteRune(r)
+		} else {
+			label.WriteRune('-')
+		}
+	}
+	if label.String() == s && len(s) <= 63 && strings.Trim(s, "-") == s && s != "" {
+		return s
+	}
+	short := strings.Trim(label.String(), "-")
+	if len(short) > 54 {
+		short = strings.TrimRight(short[:54], "-")
+	}
+	if short == "" {
+		short = "x"
+	}
+	return fmt.Sprintf("%s-%x", short, sha1.Sum([]byte(s)))[:len(short)+9]
+}
+
+// sessionEnv returns the session metadata exported into the container
+// environment, for tooling inside the session
+func sessionEnv(config *Config, user string, id string, name string, endpoint string) []string {
+	env := []string{
+		"DSSHELL_SESSION_ID=" + id,
+		"DSSHELL_SESSION=" + name,
+		"DSSHELL_OWNER=" + user,
+		"DSSHELL_ENDPOINT=" + endpoint,
+	}
+	if config.MaxAge != 0 {
+		env = append(env, fmt.Sprintf("DSSHELL_EXPIRES=%d", time.Now().Unix()+int64(config.MaxAge)))
+	}
+	return env
+}
+
+func sessionLabels(user string, name string) map[string]string {
+	return map[string]string{
+		labelManaged: "true",
+		labelOwner:   user,
+		labelName:    name,
+	}
+}
+
+type session struct {
+	Endpoint  string
+	Client    *docker.Client
+	Container docker.APIContainers
+}
+
+// listSessions returns the running sessions owned by user on every reachable
+// endpoint
+func listSessions(endpoints []string, user string) []session {
+	requireUser(user)
+	var sessions []session
+	for _, endpoint := range endpoints {
+		client, err := dockerClient(endpoint)
+		if err != nil {
+			continue
+		}
+
+		containers, err := managedContainers(client)
+		if err != nil {
+			continue
+		}
+
+		for _, container := range containers {
+			poolLabels(&container)
+			if container.Labels[labelOwner] == user {
+				sessions = append(sessions, session{endpoint, client, container})
+			}
+		}
+	}
+	return sessions
+}
+
+// managedContainers lists the running containers of dockersshell on an
+// endpoint. Docker applies the label filter, so endpoints that also run many
+// other containers answer quickly.
+func managedContainers(client *docker.Client) ([]docker.APIContainers, error) {
+	return client.ListContainers(docker.ListContainersOptions{
+		Limit:   -1,
+		Filters: map[string][]string{"label": {labelManaged + "=true"}},
+	})
+}
+
+// findSession looks through every endpoint for a running session owned by
+// user with the given name
+func findSession(endpoints []string, user string, name string) *session {
+	for _, s := range listSessions(endpoints, user) {
+		if s.Container.Labels[labelName] == name {
+			return &s
+		}
+	}
+	return nil
+}
+
+func endpointHost(endpoint string) string {
+	Url, err := url.Parse(endpoint)
+	if err != nil {
+		log.Fatal(msg("Unable to parse endpoint URL: %s\n", err))
+	} else if Url.Host == "" {
+		log.Fatal(msg("No host found in endpoint"))
+	}
+
+	return strings.SplitN(Url.Host, ":", 2)[0]
+}
+
+// sessionAddress returns the host and published SSH port of a session
+func sessionAddress(endpoint string, client *docker.Client, id string) (string, string) {
+	inspect, err := client.InspectContainer(id)
+	if err != nil {
+		log.Fatal(msg("Unable to get port information for container: %s\n", err))
+	}
+
+	bindings := inspect.NetworkSettings.Ports["22/tcp"]
+	if len(bindings) == 0 {
+		log.Fatal(msg("Container does not publish port 22"))
+	}
+
+	return connectHost(endpoint), bindings[0].HostPort
+}
+
+// probe collects the state of the given endpoints new sessions may be placed
+// on, other than skip. Only sessions count towards an endpoint's load unless
+// the placement policy says otherwise.
+func probe(config *Config, endpoints []string, skip string) []endpointState {
+	var states []endpointState
+
+	for _, endpoint := range endpoints {
+		if endpoint == skip || isDraining(config, endpoint) || inMaintenance(endpoint) {
+			continue
+		}
+
+		span := startSpan("probe", "endpoint", endpoint)
+		client, err := dockerClient(endpoint)
+		if err != nil {
+			span.End()
+			continue
+		}
+
+		containers, err := managedContainers(client)
+		span.End()
+		if err != nil {
+			continue
+		}
+
+		state := endpointState{
+			Endpoint:   endpoint,
+			Containers: len(containers),
+			FreeGPUs:   freeGPUs(endpoint, containers),
+			Cost:       hourlyCost(endpoint),
+		}
+		measureLoad(client, &state)
+		states = append(states, state)
+		if state.load() == 0 && !config.Affinity && !hasGPUs() && !hasCosts() {
+			break
+		}
+	}
+	return states
+}
+
+// authorize runs the checks every new session of user has to pass, exiting
+// when one fails, and returns the profile of the session
+func authorize(config *Config, user string, opts *sessionOptions) *Profile {
+	requireUser(user)
+	if err := checkAssignedProfile(&opts.Profile); err != nil {
+		log.Fatal(msg("Unable to use profile %s: %s\n", opts.Profile, err))
+	}
+	profile, err := config.profile(opts.Profile)
+	if err != nil {
+		log.Fatal(msg("Unable to use profile: %s\n", err))
+	}
+	if profile.Shared {
+		log.Fatal(msg("Shared profiles only support interactive sessions"))
+	}
+	if err := profile.allowed(user); err != nil {
+		audit(config, eventDenied, map[string]string{"user": user, "profile": opts.Profile, "reason": err.Error()})
+		log.Fatal(msg("Unable to use profile %s: %s\n", opts.Profile, err))
+	}
+	if err := profile.checkNetwork(); err != nil {
+		log.Fatal(msg("Unable to use profile %s: %s\n", opts.Profile, err))
+	}
+	enforcePolicies(config, user, opts.Profile)
+	if !opts.Scheduled {
+		justify(config, user, opts)
+		verifySecondFactor(config, user)
+	}
+	return profile
+}
+
+// createSession schedules, creates and starts a new session container for
+// user, returning the endpoint it was placed on
+func createSession(config *Config, user string, opts sessionOptions) (string, *docker.Client, *docker.Container) {
+	profile := authorize(config, user, &opts)
+	name, profileName := opts.Name, opts.Profile
+
+	startLaunch(user)
+	startDeadline(config, opts.Timeout)
+	// Regions are tried closest first, moving on when a region has no
+	// acceptable endpoint
+	var endpoint string
+	var gpus []string
+	for _, endpoints := range candidateEndpoints(config, opts.Region) {
+		states := probe(config, endpoints, "")
+		if profile.GPUs != 0 {
+			requireGPUs(states, profile.GPUs)
+		}
+		preflight(profileName, profile, states)
+		applyBudget(config, states, user)
+		span := startSpan("schedule")
+		endpoint = place(config, states, user)
+		span.End()
+		if endpoint != "" {
+			for _, state := range states {
+				if state.Endpoint == endpoint && profile.GPUs != 0 {
+					gpus = state.FreeGPUs[:profile.GPUs]
+				}
+			}
+			break
+		}
+	}
+	if endpoint == "" {
+		fail(ErrNoEndpoints, msg("No acceptable endpoints found"))
+	}
+	status(colorBlue, "Using endpoint %s", endpoint)
+
+	client, err := dockerClient(endpoint)
+	if err != nil {
+		fail(err, msg("Unable to communicate: %s\n", err))
+	}
+
+	stamp := strconv.FormatInt(time.Now().Unix(), 10)
+	containerName := fmt.Sprintf("%s-%s", userID(user), stamp)
+	if name == "" {
+		name = containerName
+	}
+
+	image := profile.pickImage()
+	if opts.Image != "" {
+		image = opts.Image
+		if err := pullMissing(client, image); err != nil {
+			fail(ErrCreateFailed, msg("Unable to pull %s: %s\n", image, err))
+		}
+	}
+	dockerConfig := docker.Config{Image: image, Labels: sessionLabels(user, name)}
+	dockerConfig.Labels[labelProfile] = profileName
+	dockerConfig.Labels[labelImage] = image
+	for key, value := range opts.Labels {
+		dockerConfig.Labels[key] = value
+	}
+	for key, value := range opts.Tags.labels() {
+		dockerConfig.Labels[key] = value
+	}
+	if profile.Agent != "" {
+		dockerConfig.Labels[labelAgent] = "true"
+	}
+	dockerConfig.Env = append(dockerConfig.Env, opts.Env...)
+	dockerConfig.Env = append(dockerConfig.Env, config.userEnv()+"="+user)
+	dockerConfig.Env = append(dockerConfig.Env, sessionEnv(config, user, containerName, name, endpoint)...)
+	if err := profile.identify(&dockerConfig, identity{userID(user), dnsLabel(name)}); err != nil {
+		fail(err, msg("Unable to use profile: %s\n", err))
+	}
+
+	var expires int64
+	if config.ExpiryPrompt && config.MaxAge != 0 {
+		expires = time.Now().Unix() + int64(config.MaxAge)
+	}
+
+	host := profile.hostConfig()
+	bindPorts(&host, endpoint)
+	if len(gpus) != 0 {
+		assignGPUs(&dockerConfig, &host, gpus)
+	}
+	if err := profile.raw(&dockerConfig, &host); err != nil {
+		fail(err, msg("Unable to use profile: %s\n", err))
+	}
+
+	// Pool containers were created from the default profile without per
+	// session labels, so they can only stand in for untagged sessions of the
+	// default profile, and only for users whose name can be read back from
+	// the container name. The session environment is written into them once
+	// claimed.
+	launchLock.Lock()
+	journalBegin(endpoint, containerName)
+	var container *docker.Container
+	if config.WarmPool != 0 && name == containerName && expires == 0 && profileName == "" && image == config.Image &&
+		dockerConfig.Hostname == "" && dockerConfig.MacAddress == "" && len(gpus) == 0 && profile.Agent == "" &&
+		len(opts.Env) == 0 && len(opts.Tags) == 0 && len(opts.Labels) == 0 &&
+		profile.DockerConfig == "" && profile.DockerHostConfig == "" && userID(user) == user {
+		span := startSpan("claim", "endpoint", endpoint)
+		container = claimPooled(config, endpoint, client, containerName)
+		span.End()
+		if container != nil {
+			if err := execRoot(client, container.ID, append([]string{"sh", "-c", pooledEnv, "sh"}, dockerConfig.Env...)); err != nil {
+				client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+				fail(ErrCreateFailed, msg("Unable to set the session environment: %s\n", err))
+			}
+		}
+	}
+
+	if container == nil {
+		span := startSpan("create", "endpoint", endpoint)
+		create := docker.CreateContainerOptions{Name: containerName, Config: &dockerConfig, HostConfig: &host}
+		err = injectCreateFault()
+		if err == nil {
+			container, err = client.CreateContainer(create)
+		}
+		span.End()
+		if err != nil {
+			fail(ErrCreateFailed, msg("Unable to create container: %s\n", err))
+		}
+
+		span = startSpan("start", "container", container.ID)
+		err = client.StartContainer(container.ID, &host)
+		span.End()
+		if err != nil {
+			fail(ErrCreateFailed, msg("Unable to start container: %s\n", err))
+		}
+	}
+	launchLock.Unlock()
+
+	if err := limitNetwork(client, container.ID, profile); err != nil {
+		client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+		fail(ErrCreateFailed, msg("Unable to limit network: %s\n", err))
+	}
+	if profile.Agent != "" {
+		if err := installAgent(client, container.ID, profile.Agent); err != nil {
+			client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+			fail(ErrCreateFailed, msg("Unable to install agent: %s\n", err))
+		}
+	}
+
+	if expires != 0 {
+		installPromptHook(client, container.ID, expires)
+	}
+
+	container.Config = &dockerConfig
+	notify(config, eventCreate, "%s created session %s on %s", user, name, endpoint)
+	fields := map[string]string{"user": user, "session": name, "endpoint": endpoint}
+	if opts.Reason != "" {
+		fields["justification"] = opts.Reason
+	}
+	if opts.Ticket != "" {
+		fields["ticket"] = opts.Ticket
+	}
+	audit(config, eventCreate, fields)
+	if profile.Privileged {
+		status(colorYellow, "Session %s is privileged and has full access to %s", name, endpoint)
+		notify(config, eventPrivileged, "%s created PRIVILEGED session %s on %s", user, name, endpoint)
+		audit(config, eventPrivileged, map[string]string{"user": user, "session": name, "endpoint": endpoint, "profile": profileName})
+	}
+	address, port := sessionAddress(endpoint, client, container.ID)
+	registerSession(config, user, name, address, port)
+	return endpoint, client, container
+}
+
+// destroySession removes a session and records it in the history, with the
+// exit status of the user's shell if they were connected, or -1. With an
+// undelete_window the container is only stopped and set aside.
+func destroySession(config *Config, endpoint string, client *docker.Client, id string, name string, code int) {
+	fields := map[string]string{"user": invoker, "session": name, "endpoint": endpoint}
+	for key, value := range sessionUsage(client, id) {
+		fields[key] = value
+	}
+	var port, container string
+	entry := historyEntry{Name: name, Endpoint: endpoint, End: time.Now().Unix(), ExitCode: code}
+	if inspect, err := client.InspectContainer(id); err == nil {
+		container = dockerName(inspect)
+		if bindings := inspect.NetworkSettings.Ports["22/tcp"]; len(bindings) != 0 {
+			port = bindings[0].HostPort
+		}
+		if hourlyCost(endpoint) != 0 {
+			fields["cost"] = sessionCost(endpoint, inspect.Created)
+		}
+		entry.Owner = sessionOwner(container, inspect.Config.Labels)
+		entry.Profile = inspect.Config.Labels[labelProfile]
+		entry.Start = inspect.Created.Unix()
+	}
+
+	if err := client.StopContainer(id, 0); err != nil {
+		log.Fatal(msg("Unable to stop container: %s\n", err))
+	}
+
+	// Sessions removed by an admin are gone for good, so that their owner
+	// cannot bring them back
+	if config.UndeleteWindow != 0 && container != "" && entry.Owner == invoker {
+		if err := softDelete(config, client, id, container); err != nil {
+			log.Fatal(msg("Unable to remove container: %s\n", err))
+		}
+	} else {
+		remove := docker.RemoveContainerOptions{ID: id, RemoveVolumes: false}
+		if err := client.RemoveContainer(remove); err != nil {
+			log.Fatal(msg("Unable to remove container: %s\n", err))
+		}
+		status(colorGreen, "Removed session")
+	}
+	deregisterSession(config, entry.Owner, name)
+	recordHistory(config, entry)
+	if entry.Owner == invoker {
+		forgetSession(name, connectHost(endpoint), port)
+	}
+	notify(config, eventRemove, "Session %s was removed", name)
+	audit(config, eventRemove, fields)
+}
+
+// dockerName returns the docker name of a session container
+func dockerName(container *docker.Container) string {
+	return strings.TrimPrefix(container.Name, "/")
+}
+
+// attach connects to the session with the first of the profile's connection
+// methods that is available, watching the session for resource problems
+// while connected, and returns the exit status of the user's shell. ssh
+// needs the ssh binary and the session's sshd to answer, exec is a login
+// shell through docker exec.
+func attach(config *Config, endpoint string, client *docker.Client, id string, name string, labels map[string]string, host string, port string) int {
+	profile := sessionProfile(config, labels)
+	methods := profile.Connect
+	if len(methods) == 0 {
+		methods = []string{"ssh"}
+	}
+
+	// Shared containers have an account per user
+	login := profile.User
+	if labels[labelShared] != "" {
+		login = userID(labels[labelOwner])
+	}
+
+	for _, method := range methods {
+		var strict bool
+		switch method {
+		case "ssh":
+			if _, err := exec.LookPath(config.SSHClient.command()); err != nil {
+				status(colorYellow, "Unable to connect with ssh: %s", err)
+				continue
+			}
+			status(colorYellow, "Waiting for %s:%s", host, port)
+			span := startSpan("wait")
+			ready := sshReady(endpoint, host, port)
+			span.End()
+			if !ready {
+				status(colorYellow, "Unable to connect with ssh: %s:%s never became available", host, port)
+				continue
+			}
+			strict = publishHostKeys(client, id, host, port)
+			status(colorGreen, "Connecting to %s:%s with ssh", host, port)
+		case "exec":
+			status(colorGreen, "Connecting to %s with exec", name)
+		default:
+			status(colorYellow, "Unknown connection method %s", method)
+			continue
+		}
+		audit(config, eventConnect, map[string]string{"user": invoker, "session": name, "endpoint": host, "method": method})
+		stopDeadline()
+
+		done := make(chan bool)
+		if config.Warnings {
+			go watchResources(client, id, done)
+		}
+		var code int
+		if method == "ssh" {
+			var err error
+			if code, err = connect(&config.SSHClient, endpoint, login, host, port, strict, config.userEnv()); err != nil {
+				fail(ErrSSHFailed, msg("Unable to initiate ssh connection: %s\n", err))
+			}
+		} else {
+			var err error
+			if code, err = execShell(client, id, login); err != nil {
+				fail(ErrSSHFailed, msg("Unable to exec a shell: %s\n", err))
+			}
+		}
+		close(done)
+		return code
+	}
+	fail(ErrSSHFailed, msg("No connection method is available"))
+	return 0
+}
+
+// run is the classic login shell behaviour: create a session, connect to it
+// and remove it once the connection is closed
+func run(config *Config, user string, opts sessionOptions) {
+	recoverJournal(config)
+	if err := checkAssignedProfile(&opts.Profile); err != nil {
+		log.Fatal(msg("Unable to use profile %s: %s\n", opts.Profile, err))
+	}
+	if profile, err := config.profile(opts.Profile); err == nil && profile.Shared {
+		runShared(config, user, opts, profile)
+		return
+	}
+	if opts.Name != "" {
+		if s := findSession(config.Endpoints, user, opts.Name); s != nil {
+			log.Fatal(msg("Session %s already exists on %s", opts.Name, s.Endpoint))
+		}
+	}
+
+	endpoint, client, container := createSession(config, user, opts)
+	saveLastOptions(opts)
+	name := container.Config.Labels[labelName]
+	host, port := sessionAddress(endpoint, client, container.ID)
+
+	if opts.SSHConfig {
+		if err := addSSHConfigEntry(name, endpoint, sessionProfile(config, container.Config.Labels).User, host, port); err != nil {
+			fmt.Print(msg("Unable to update ssh config: %s\n", err))
+		}
+	}
+
+	code := attach(config, endpoint, client, container.ID, name, container.Config.Labels, host, port)
+	destroySession(config, endpoint, client, container.ID, name, code)
+	journalEnd(dockerName(container))
+}
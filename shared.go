@@ -0,0 +1,231 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// labelShared marks the long-lived container of a shared profile, which
+// hosts an account per user instead of a container per user
+const labelShared = "dockersshell.shared"
+
+// ownAccount is true for accounts created by addAccount, so that accounts
+// of the image, such as root or its login user, are never taken over or
+// removed
+const ownAccount = `[ "$(getent passwd "$1" | cut -d: -f5)" = dockersshell ] && [ "$(id -u "$1")" -ge 1000 ]`
+
+// addAccount creates the account of user in a shared container, if needed,
+// and authorizes their keys for it
+const addAccount = `if id -u "$1" >/dev/null 2>&1; then
+	` + ownAccount + ` || { echo "account $1 belongs to the image" >&2; exit 1; }
+else
+	useradd -m -c dockersshell -K UID_MIN=1000 -s /bin/bash "$1" || exit 1
+fi
+` + authorizeKeys
+
+// authorizeKeys authorizes keys for an existing account
+const authorizeKeys = `home=$(getent passwd "$1" | cut -d: -f6)
+[ -n "$home" ] || exit 1
+mkdir -p "$home/.ssh"
+printf '%s\n' "$2" > "$home/.ssh/authorized_keys"
+chown -R "$1:" "$home/.ssh"
+chmod 700 "$home/.ssh"
+chmod 600 "$home/.ssh/authorized_keys"
+`
+
+// removeAccount removes the account and home of user once they have no ssh
+// connection left
+const removeAccount = ownAccount + ` || exit 1
+pgrep -u "$1" -x sshd >/dev/null && exit 0
+pkill -KILL -u "$1"
+userdel -r "$1"
+`
+
+// execRoot runs cmd as root in a container and fails unless it exits 0
+func execRoot(client *docker.Client, id string, cmd []string) error {
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		Container:    id,
+		User:         "root",
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return err
+	}
+
+	var output bytes.Buffer
+	opts := docker.StartExecOptions{OutputStream: &output, ErrorStream: &output}
+	if err := client.StartExec(exec.ID, opts); err != nil {
+		return err
+	}
+
+	inspect, err := client.InspectExec(exec.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("%s exited with %d: %s", cmd[0], inspect.ExitCode, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// publicKeys returns the default ssh identities of the user, which ssh
+// offers when connecting
+func publicKeys() (string, error) {
+	paths, _ := filepath.Glob(filepath.Join(os.Getenv("HOME"), ".ssh", "id_*.pub"))
+	var keys []string
+	for _, path := range paths {
+		text, err := ioutil.ReadFile(path)
+		if err == nil {
+			keys = append(keys, strings.TrimSpace(string(text)))
+		}
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("no public keys in ~/.ssh")
+	}
+	return strings.Join(keys, "\n"), nil
+}
+
+// findShared returns the running container of a shared profile, if any
+func findShared(config *Config, profileName string) (string, *docker.Client, string) {
+	listOptions := docker.ListContainersOptions{
+		Limit:   -1,
+		Filters: map[string][]string{"label": {labelShared + "=" + profileName}},
+	}
+	for _, endpoint := range config.Endpoints {
+		client, err := dockerClient(endpoint)
+		if err != nil {
+			continue
+		}
+		containers, err := client.ListContainers(listOptions)
+		if err == nil && len(containers) != 0 {
+			return endpoint, client, containers[0].ID
+		}
+	}
+	return "", nil, ""
+}
+
+// sharedContainer finds the running container of a shared profile, or
+// creates one on the endpoint the scheduler picks
+func sharedContainer(config *Config, profileName string, profile *Profile, region string) (string, *docker.Client, string) {
+	if endpoint, client, id := findShared(config, profileName); id != "" {
+		return endpoint, client, id
+	}
+
+	var endpoint string
+	for _, endpoints := range candidateEndpoints(config, region) {
+		if endpoint = schedule(probe(config, endpoints, "")); endpoint != "" {
+			break
+		}
+	}
+	if endpoint == "" {
+		fail(ErrNoEndpoints, msg("No acceptable endpoints found"))
+	}
+
+	client, err := dockerClient(endpoint)
+	if err != nil {
+		log.Fatal(msg("Unable to communicate: %s\n", err))
+	}
+
+	dockerConfig := docker.Config{
+		Image:  profile.pickImage(),
+		Labels: map[string]string{labelManaged: "true", labelShared: profileName, labelProfile: profileName},
+	}
+	host := profile.hostConfig()
+	bindPorts(&host, endpoint)
+	if err := profile.raw(&dockerConfig, &host); err != nil {
+		log.Fatal(msg("Unable to use profile: %s\n", err))
+	}
+	name := "dockersshell-shared-" + profileName
+	// Other users share the container, so a launch timing out must not
+	// leave it half set up
+	launchLock.Lock()
+	defer launchLock.Unlock()
+	container, err := client.CreateContainer(docker.CreateContainerOptions{Name: name, Config: &dockerConfig, HostConfig: &host})
+	if err != nil {
+		// Another user may have just created it
+		if endpoint, client, id := findShared(config, profileName); id != "" {
+			return endpoint, client, id
+		}
+		fail(ErrCreateFailed, msg("Unable to create container: %s\n", err))
+	}
+	if err := client.StartContainer(container.ID, &host); err != nil {
+		fail(ErrCreateFailed, msg("Unable to start container: %s\n", err))
+	}
+	if err := limitNetwork(client, container.ID, profile); err != nil {
+		client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+		fail(ErrCreateFailed, msg("Unable to limit network: %s\n", err))
+	}
+	status(colorBlue, "Started shared container for %s on %s", profileName, endpoint)
+	return endpoint, client, container.ID
+}
+
+// runShared is run for shared profiles: the user gets an account in the
+// profile's shared container, which is removed with their home directory
+// when they disconnect
+func runShared(config *Config, user string, opts sessionOptions, profile *Profile) {
+	if err := checkAssignedProfile(&opts.Profile); err != nil {
+		log.Fatal(msg("Unable to use profile %s: %s\n", opts.Profile, err))
+	}
+	if err := profile.allowed(user); err != nil {
+		audit(config, eventDenied, map[string]string{"user": user, "profile": opts.Profile, "reason": err.Error()})
+		log.Fatal(msg("Unable to use profile %s: %s\n", opts.Profile, err))
+	}
+	enforcePolicies(config, user, opts.Profile)
+	justify(config, user, &opts)
+	verifySecondFactor(config, user)
+	startDeadline(config, opts.Timeout)
+
+	keys, err := publicKeys()
+	if err != nil {
+		log.Fatal(msg("Unable to use shared profile: %s\n", err))
+	}
+
+	endpoint, client, id := sharedContainer(config, opts.Profile, profile, opts.Region)
+	if err := execRoot(client, id, []string{"sh", "-c", addAccount, "sh", userID(user), keys}); err != nil {
+		log.Fatal(msg("Unable to create account: %s\n", err))
+	}
+	fields := map[string]string{"user": user, "session": opts.Profile, "endpoint": endpoint, "detail": "shared"}
+	if opts.Reason != "" {
+		fields["justification"] = opts.Reason
+	}
+	if opts.Ticket != "" {
+		fields["ticket"] = opts.Ticket
+	}
+	audit(config, eventCreate, fields)
+
+	labels := map[string]string{labelOwner: user, labelProfile: opts.Profile, labelShared: opts.Profile}
+	host, port := sessionAddress(endpoint, client, id)
+	start := time.Now().Unix()
+	code := attach(config, endpoint, client, id, opts.Profile, labels, host, port)
+	recordHistory(config, historyEntry{Owner: user, Name: opts.Profile, Profile: opts.Profile, Endpoint: endpoint, Start: start, End: time.Now().Unix(), ExitCode: code})
+
+	if err := execRoot(client, id, []string{"sh", "-c", removeAccount, "sh", userID(user)}); err != nil {
+		log.Print(msg("Unable to remove account: %s\n", err))
+	}
+	audit(config, eventRemove, map[string]string{"user": user, "session": opts.Profile, "endpoint": endpoint, "detail": "shared"})
+}
@@ -0,0 +1,88 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// stty runs stty on the terminal and returns what it printed
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+// execShell gives user a login shell in a session through docker exec, for
+// when SSH cannot be used, and returns its exit status. The local terminal is
+// put in raw mode while the shell runs.
+func execShell(client *docker.Client, id string, user string) (int, error) {
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		Container:    id,
+		User:         "root",
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+		Env:          []string{"TERM=" + os.Getenv("TERM")},
+		Cmd:          []string{"su", "-", user},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if saved, err := stty("-g"); err == nil {
+		defer stty(saved)
+		stty("raw", "-echo")
+	}
+
+	success := make(chan struct{})
+	go func() {
+		<-success
+		endLaunch()
+		if size, err := stty("size"); err == nil {
+			if fields := strings.Fields(size); len(fields) == 2 {
+				height, _ := strconv.Atoi(fields[0])
+				width, _ := strconv.Atoi(fields[1])
+				client.ResizeExecTTY(exec.ID, height, width)
+			}
+		}
+		success <- struct{}{}
+	}()
+
+	err = client.StartExec(exec.ID, docker.StartExecOptions{
+		InputStream:  os.Stdin,
+		OutputStream: os.Stdout,
+		ErrorStream:  os.Stderr,
+		Tty:          true,
+		RawTerminal:  true,
+		Success:      success,
+	})
+	if err != nil {
+		return 0, err
+	}
+	inspect, err := client.InspectExec(exec.ID)
+	if err != nil {
+		return 0, err
+	}
+	return inspect.ExitCode, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+// SSHClientConfig replaces the ssh binary used to connect to sessions, for
+// wrappers such as autossh or clients such as tsh. Every argument is
+// expanded as a template on its own and passed without a shell, so values
+// never need quoting. An argument of exactly {{.Options}} is replaced by the
+// options dockersshell would pass to ssh.
+//
+// PuTTY's plink takes different options. It is used when command names it,
+// or on Windows when there is no ssh but plink is on the PATH.
+type SSHClientConfig struct {
+	Command string   `yaml:"command,omitempty"`
+	Args    []string `yaml:"args,omitempty"`
+}
+
+// sshTarget is what ssh_client arguments are expanded with
+type sshTarget struct {
+	Endpoint string
+	User     string
+	Host     string
+	Port     string
+	Options  []string
+}
+
+const optionsArg = "{{.Options}}"
+
+func (c *SSHClientConfig) command() string {
+	if c.Command != "" {
+		return c.Command
+	}
+	if runtime.GOOS == "windows" {
+		if _, err := exec.LookPath("ssh"); err != nil {
+			if _, err := exec.LookPath("plink"); err == nil {
+				return "plink"
+			}
+		}
+	}
+	return "ssh"
+}
+
+// plink reports whether the client is PuTTY's plink
+func (c *SSHClientConfig) plink() bool {
+	name := strings.ToLower(filepath.Base(c.command()))
+	return strings.TrimSuffix(name, ".exe") == "plink"
+}
+
+// options returns the options for connecting to host:port. plink has no -o,
+// pins host keys by fingerprint and spells the proxy placeholders out, and
+// its proxy command is run by cmd.exe, which only knows double quotes.
+func (c *SSHClientConfig) options(endpoint string, host string, port string, strict bool, env string) []string {
+	var options []string
+	proxy := proxyCommand(endpoint, host, port)
+	if c.plink() {
+		if strict {
+			for _, key := range knownHostKeys(host, port) {
+				if hash, err := keyHash(key); err == nil {
+					options = append(options, "-hostkey", hash)
+				}
+			}
+		}
+		if nonInteractive {
+			options = append(options, "-batch")
+		}
+		if proxy != "" {
+			proxy = strings.NewReplacer("%h", "%host", "%p", "%port", "'", `"`).Replace(proxy)
+			options = append(options, "-proxycmd", proxy)
+		}
+		return options
+	}
+
+	options = append(options, "-o", "SendEnv="+env)
+	if strict {
+		options = append(options, "-o", "UserKnownHostsFile="+knownHostsPath(), "-o", "StrictHostKeyChecking=yes")
+	}
+	if nonInteractive {
+		// Unknown host keys and passwords fail instead of prompting
+		options = append(options, "-o", "BatchMode=yes")
+	}
+	if proxy != "" {
+		options = append(options, "-o", "ProxyCommand="+proxy)
+	}
+	return options
+}
+
+// args returns the arguments for connecting to target. Without configured
+// arguments they are those of ssh.
+func (c *SSHClientConfig) args(target sshTarget) ([]string, error) {
+	templates := c.Args
+	if len(templates) == 0 && c.plink() {
+		templates = []string{"-ssh", "-t", "-P", "{{.Port}}", "-l", "{{.User}}", optionsArg, "{{.Host}}"}
+	} else if len(templates) == 0 {
+		templates = []string{"-q", "-p", "{{.Port}}", "-l", "{{.User}}", optionsArg, "{{.Host}}"}
+	}
+
+	var args []string
+	for _, text := range templates {
+		if text == optionsArg {
+			args = append(args, target.Options...)
+			continue
+		}
+		t, err := template.New("").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := t.Execute(&out, target); err != nil {
+			return nil, err
+		}
+		args = append(args, out.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const sshInclude = "Include dockersshell_config"
+
+func sshConfigPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "config")
+}
+
+func sshIncludePath() string {
+	return filepath.Join(os.Getenv("HOME"), ".ssh", "dockersshell_config")
+}
+
+// ensureSSHInclude makes sure ~/.ssh/config includes the managed session file.
+// Include has to come before any Host stanza to apply globally, so it is
+// prepended.
+func ensureSSHInclude() error {
+	path := sshConfigPath()
+	text, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, line := range strings.Split(string(text), "\n") {
+		if strings.TrimSpace(line) == sshInclude {
+			return nil
+		}
+	}
+
+	content := sshInclude + "\n"
+	if len(text) > 0 {
+		content += "\n" + string(text)
+	}
+
+	return ioutil.WriteFile(path, []byte(content), 0600)
+}
+
+func sshConfigMarkers(name string) (string, string) {
+	return fmt.Sprintf("# BEGIN dockersshell %s", name), fmt.Sprintf("# END dockersshell %s", name)
+}
+
+// stripSSHConfigEntry returns text with the marked Host stanza for name removed
+func stripSSHConfigEntry(text string, name string) string {
+	begin, end := sshConfigMarkers(name)
+	var lines []string
+	skip := false
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case line == begin:
+			skip = true
+		case line == end:
+			skip = false
+		case !skip:
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func addSSHConfigEntry(name string, endpoint string, user string, host string, port string) error {
+	path := sshIncludePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	if err := ensureSSHInclude(); err != nil {
+		return err
+	}
+
+	text, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	content := strings.TrimRight(stripSSHConfigEntry(string(text), name), "\n")
+	if content != "" {
+		content += "\n\n"
+	}
+
+	begin, end := sshConfigMarkers(name)
+	content += fmt.Sprintf("%s\nHost %s\n    HostName %s\n    Port %s\n    User %s\n", begin, name, host, port, user)
+	content += fmt.Sprintf("    UserKnownHostsFile %s\n    StrictHostKeyChecking yes\n", knownHostsPath())
+	if command := proxyCommand(endpoint, host, port); command != "" {
+		content += fmt.Sprintf("    ProxyCommand %s\n", command)
+	}
+	content += end + "\n"
+
+	return ioutil.WriteFile(path, []byte(content), 0600)
+}
+
+// updateSSHConfigEntry points an existing entry for name at a new address,
+// leaving sessions without an entry alone
+func updateSSHConfigEntry(name string, endpoint string, user string, host string, port string) error {
+	text, err := ioutil.ReadFile(sshIncludePath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	begin, _ := sshConfigMarkers(name)
+	if !strings.Contains(string(text), begin+"\n") {
+		return nil
+	}
+	return addSSHConfigEntry(name, endpoint, user, host, port)
+}
+
+func removeSSHConfigEntry(name string) error {
+	path := sshIncludePath()
+	text, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	content := strings.TrimRight(stripSSHConfigEntry(string(text), name), "\n")
+	if content != "" {
+		content += "\n"
+	}
+
+	return ioutil.WriteFile(path, []byte(content), 0600)
+}
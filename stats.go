@@ -0,0 +1,135 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func cpuPercent(stats *docker.Stats) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemCPUUsage) - float64(stats.PreCPUStats.SystemCPUUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for i := n / unit; i >= unit; i /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatStats(name string, stats *docker.Stats) string {
+	var rx, tx uint64
+	for _, network := range stats.Networks {
+		rx += network.RxBytes
+		tx += network.TxBytes
+	}
+	return fmt.Sprintf("%-30s %6.2f%% %10s / %-10s %10s / %-10s", name, cpuPercent(stats),
+		humanBytes(stats.MemoryStats.Usage), humanBytes(stats.MemoryStats.Limit), humanBytes(rx), humanBytes(tx))
+}
+
+// statsOnce returns a single stats sample for a container, or nil
+func statsOnce(client *docker.Client, id string) *docker.Stats {
+	stats := make(chan *docker.Stats, 1)
+	done := make(chan bool)
+	defer close(done)
+	go client.Stats(docker.StatsOptions{ID: id, Stats: stats, Stream: false, Done: done, Timeout: 10 * time.Second})
+
+	s, ok := <-stats
+	if !ok {
+		return nil
+	}
+	return s
+}
+
+// sessionUsage returns the cumulative CPU seconds and peak memory of a
+// session for chargeback. It has to be called before the container stops.
+// Peak memory is only reported by cgroup v1 hosts.
+func sessionUsage(client *docker.Client, id string) map[string]string {
+	s := statsOnce(client, id)
+	if s == nil {
+		return nil
+	}
+
+	usage := map[string]string{
+		"cpu_seconds": fmt.Sprintf("%.3f", float64(s.CPUStats.CPUUsage.TotalUsage)/1e9),
+	}
+	if s.MemoryStats.MaxUsage != 0 {
+		usage["peak_memory"] = strconv.FormatUint(s.MemoryStats.MaxUsage, 10)
+	}
+	return usage
+}
+
+// showStats streams CPU, memory and network usage for the sessions owned by
+// user, or only the named session, until interrupted
+func showStats(endpoints []string, user string, name string) {
+	var sessions []session
+	for _, s := range listSessions(endpoints, user) {
+		if name == "" || s.Container.Labels[labelName] == name {
+			sessions = append(sessions, s)
+		}
+	}
+
+	if len(sessions) == 0 {
+		log.Fatal(msg("No sessions found"))
+	}
+
+	fmt.Printf("%-30s %7s %23s %23s\n", "NAME", "CPU", "MEM USAGE / LIMIT", "NET RX / TX")
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		wg.Add(1)
+		go func(s session) {
+			defer wg.Done()
+			statsC := make(chan *docker.Stats)
+			errC := make(chan error, 1)
+			go func() {
+				errC <- s.Client.Stats(docker.StatsOptions{ID: s.Container.ID, Stats: statsC, Stream: true})
+			}()
+			for stats := range statsC {
+				lock.Lock()
+				fmt.Println(formatStats(s.Container.Labels[labelName], stats))
+				lock.Unlock()
+			}
+			if err := <-errC; err != nil {
+				log.Print(msg("Unable to get stats for %s: %s\n", s.Container.Labels[labelName], err))
+			}
+		}(s)
+	}
+	wg.Wait()
+}
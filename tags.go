@@ -0,0 +1,76 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// labelTag prefixes the labels of session tags
+const labelTag = "dockersshell.tag."
+
+// tagFlags collects -tag KEY=VALUE flags, given any number of times
+type tagFlags map[string]string
+
+func (t tagFlags) String() string {
+	var tags []string
+	for key, value := range t {
+		tags = append(tags, key+"="+value)
+	}
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
+
+func (t tagFlags) Set(text string) error {
+	parts := strings.SplitN(text, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[0], "=,") {
+		return fmt.Errorf("tags must be KEY=VALUE")
+	}
+	t[parts[0]] = parts[1]
+	return nil
+}
+
+// labels returns the tags as container labels
+func (t tagFlags) labels() map[string]string {
+	labels := map[string]string{}
+	for key, value := range t {
+		labels[labelTag+key] = value
+	}
+	return labels
+}
+
+// matchTags reports whether labels carry every one of tags
+func matchTags(labels map[string]string, tags tagFlags) bool {
+	for key, value := range tags {
+		if found, ok := labels[labelTag+key]; !ok || found != value {
+			return false
+		}
+	}
+	return true
+}
+
+// sessionTags returns the tags of a session as KEY=VALUE,...
+func sessionTags(labels map[string]string) string {
+	tags := tagFlags{}
+	for label, value := range labels {
+		if strings.HasPrefix(label, labelTag) {
+			tags[strings.TrimPrefix(label, labelTag)] = value
+		}
+	}
+	return tags.String()
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"launchpad.net/goyaml"
+)
+
+// launchTimer fails the launch once -timeout has passed
+var launchTimer *time.Timer
+
+// launchLock is held by the launch while it creates or claims the session
+// container. The deadline takes it before rolling back, so that it neither
+// misses a container whose creation is under way nor exits half way through
+// creating one.
+var launchLock sync.Mutex
+
+// startDeadline bounds picking an endpoint, creating the session, waiting
+// for it and setting up the connection by timeout. When it passes, whatever
+// this process created is removed and dockersshell exits with
+// ErrLaunchTimeout.
+func startDeadline(config *Config, timeout time.Duration) {
+	if timeout <= 0 || launchTimer != nil {
+		return
+	}
+	launchTimer = time.AfterFunc(timeout, func() {
+		launchLock.Lock()
+		rollbackLaunch(config)
+		fail(ErrLaunchTimeout, msg("The session was not ready within %s", timeout))
+	})
+}
+
+// stopDeadline is called once the session is handed over. If the deadline
+// has already passed it waits for the rollback to exit.
+func stopDeadline() {
+	if launchTimer != nil && !launchTimer.Stop() {
+		select {}
+	}
+	launchTimer = nil
+}
+
+// rollbackLaunch removes the containers journaled by this process. Entries
+// that cannot be removed are left for recover.
+func rollbackLaunch(config *Config) {
+	paths, _ := filepath.Glob(filepath.Join(journalDir(), "*"))
+	for _, path := range paths {
+		text, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry journalEntry
+		if goyaml.Unmarshal(text, &entry) != nil || entry.PID != os.Getpid() {
+			continue
+		}
+
+		client, err := dockerClient(entry.Endpoint)
+		if err != nil {
+			continue
+		}
+		container, err := client.InspectContainer(entry.Name)
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			os.Remove(path)
+			continue
+		} else if err != nil {
+			continue
+		}
+		if client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true}) != nil {
+			continue
+		}
+		status(colorYellow, "Removed %s after the launch timed out", entry.Name)
+		deregisterSession(config, container.Config.Labels[labelOwner], container.Config.Labels[labelName])
+		os.Remove(path)
+	}
+}
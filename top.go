@@ -0,0 +1,134 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+type topRow struct {
+	Endpoint string
+	Name     string
+	Owner    string
+	CPU      float64
+	Memory   uint64
+}
+
+// fleetStats samples every running session on every endpoint in parallel
+func fleetStats(endpoints []string, tags tagFlags) []topRow {
+	var rows []topRow
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, endpoint := range endpoints {
+		client, err := dockerClient(endpoint)
+		if err != nil {
+			continue
+		}
+
+		containers, err := managedContainers(client)
+		if err != nil {
+			continue
+		}
+
+		for _, container := range containers {
+			poolLabels(&container)
+			if container.Labels[labelOwner] == "" || !matchTags(container.Labels, tags) {
+				continue
+			}
+
+			wg.Add(1)
+			go func(endpoint string, client *docker.Client, container docker.APIContainers) {
+				defer wg.Done()
+				stats := statsOnce(client, container.ID)
+				if stats == nil {
+					return
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+				rows = append(rows, topRow{endpoint, container.Labels[labelName], container.Labels[labelOwner],
+					cpuPercent(stats), stats.MemoryStats.Usage})
+			}(endpoint, client, container)
+		}
+	}
+	wg.Wait()
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Endpoint != rows[j].Endpoint {
+			return rows[i].Endpoint < rows[j].Endpoint
+		}
+		return rows[i].CPU > rows[j].CPU
+	})
+	return rows
+}
+
+func printTop(rows []topRow) {
+	fmt.Printf("%-30s %-30s %-15s %7s %10s\n", "ENDPOINT", "NAME", "OWNER", "CPU", "MEM")
+	for _, row := range rows {
+		fmt.Printf("%-30s %-30s %-15s %6.2f%% %10s\n", row.Endpoint, row.Name, row.Owner, row.CPU, humanBytes(row.Memory))
+	}
+
+	var endpoints []string
+	totals := map[string]*topRow{}
+	counts := map[string]int{}
+	for _, row := range rows {
+		total, ok := totals[row.Endpoint]
+		if !ok {
+			total = &topRow{Endpoint: row.Endpoint}
+			totals[row.Endpoint] = total
+			endpoints = append(endpoints, row.Endpoint)
+		}
+		counts[row.Endpoint]++
+		total.CPU += row.CPU
+		total.Memory += row.Memory
+	}
+
+	fmt.Printf("\n%-30s %-46s %7s %10s\n", "ENDPOINT", "SESSIONS", "CPU", "MEM")
+	for _, endpoint := range endpoints {
+		total := totals[endpoint]
+		fmt.Printf("%-30s %-46d %6.2f%% %10s\n", endpoint, counts[endpoint], total.CPU, humanBytes(total.Memory))
+	}
+}
+
+// runTop shows a refreshing view of the resource usage of every session on
+// every endpoint, for operators
+func runTop(config *Config, user string, args []string) {
+	fs := findCommand("top").flags()
+	interval := fs.Duration("interval", 2*time.Second, "Time between refreshes")
+	once := fs.Bool("once", false, "Print a single sample and exit")
+	tags := tagFlags{}
+	fs.Var(tags, "tag", "Only show sessions tagged KEY=VALUE, can be repeated")
+	fs.Parse(args)
+
+	for {
+		rows := fleetStats(config.Endpoints, tags)
+		if !*once && isTerminal(os.Stdout) {
+			fmt.Print("\033[H\033[2J")
+		}
+		printTop(rows)
+		if *once {
+			return
+		}
+		time.Sleep(*interval)
+	}
+}
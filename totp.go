@@ -0,0 +1,168 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// totpAttempts is how many codes a user may enter before giving up
+const totpAttempts = 3
+
+type TOTPConfig struct {
+	Secrets string `yaml:"secrets,omitempty"`
+}
+
+// unixUserName matches the user names useradd accepts, none of which can
+// leave the directory they are put in
+var unixUserName = regexp.MustCompile(`^[a-z_][a-z0-9_.-]{0,31}$`)
+
+// secretPath returns the file holding user's base32 TOTP secret. Secrets is
+// a path with %s standing in for the user name. The name may come from a
+// certificate, a token or the environment, so only unix user names are
+// taken.
+func (c *TOTPConfig) secretPath(user string) (string, error) {
+	if !unixUserName.MatchString(user) {
+		return "", fmt.Errorf("%q is not a valid user name", user)
+	}
+	return fmt.Sprintf(c.Secrets, user), nil
+}
+
+// totpDir holds the last step each user entered a code for, so that a code
+// is only accepted once. It is one of the userDirs.
+func totpDir(config *Config) string {
+	return filepath.Join(config.stateDir(), "totp")
+}
+
+// lastTOTPStep returns the step of the last code user entered, or 0
+func lastTOTPStep(config *Config, user string) int64 {
+	f, err := openUserFile(filepath.Join(totpDir(config), user), currentUser(), os.O_RDONLY)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	text, _ := ioutil.ReadAll(f)
+	step, _ := strconv.ParseInt(strings.TrimSpace(string(text)), 10, 64)
+	return step
+}
+
+func saveTOTPStep(config *Config, user string, step int64) error {
+	if err := checkUserDir(totpDir(config)); err != nil {
+		return err
+	}
+	f, err := openUserFile(filepath.Join(totpDir(config), user), currentUser(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, step)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// totpCode computes the RFC 6238 code for secret at the given 30 second step
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// validTOTP accepts codes from the current step and the ones either side of
+// it, to allow for clock skew, and returns the step the code is for
+func validTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	step := now.Unix() / 30
+	for _, s := range []int64{step - 1, step, step + 1} {
+		if hmac.Equal([]byte(totpCode(secret, s)), []byte(code)) {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+func loadTOTPSecret(path string) ([]byte, error) {
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	encoded := strings.ToUpper(strings.Replace(strings.TrimSpace(string(text)), " ", "", -1))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(encoded, "="))
+}
+
+// verifySecondFactor asks user for a TOTP code before a session is created.
+// It does nothing unless totp secrets are configured, and exits when the
+// user has no secret or fails to enter a valid code. A code is refused once
+// it, or a later one, has been accepted.
+func verifySecondFactor(config *Config, user string) {
+	if config.TOTP.Secrets == "" {
+		return
+	}
+
+	path, err := config.TOTP.secretPath(user)
+	var secret []byte
+	if err == nil {
+		secret, err = loadTOTPSecret(path)
+	}
+	if err != nil {
+		audit(config, eventDenied, map[string]string{"user": user, "reason": "no totp secret"})
+		log.Fatal(msg("Unable to read second factor secret: %s\n", err))
+	}
+
+	noPrompt("Second factor verification")
+	reader := bufio.NewReader(os.Stdin)
+	for i := 0; i < totpAttempts; i++ {
+		fmt.Fprint(os.Stderr, msg("Verification code: "))
+		code, err := reader.ReadString('\n')
+		step, ok := validTOTP(secret, strings.TrimSpace(code), time.Now())
+		if ok && step > lastTOTPStep(config, user) {
+			if err := saveTOTPStep(config, user, step); err != nil {
+				log.Fatal(msg("Unable to record the verification code: %s\n", err))
+			}
+			return
+		}
+		if err != nil {
+			break
+		}
+		if ok {
+			status(colorYellow, "Verification code already used")
+			continue
+		}
+		status(colorYellow, "Invalid verification code")
+	}
+
+	audit(config, eventDenied, map[string]string{"user": user, "reason": "invalid totp code"})
+	log.Fatal(msg("Second factor verification failed"))
+}
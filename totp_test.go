@@ -0,0 +1,89 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// The RFC 6238 test secret, with the last six digits of its SHA1 codes
+var rfcSecret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, test := range tests {
+		if got := totpCode(rfcSecret, test.time/30); got != test.want {
+			t.Errorf("totpCode at %d = %s, want %s", test.time, got, test.want)
+		}
+	}
+}
+
+func TestValidTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / 30
+	tests := []struct {
+		code string
+		ok   bool
+		step int64
+	}{
+		{totpCode(rfcSecret, step), true, step},
+		{totpCode(rfcSecret, step-1), true, step - 1},
+		{totpCode(rfcSecret, step+1), true, step + 1},
+		{totpCode(rfcSecret, step-2), false, 0},
+		{totpCode(rfcSecret, step+2), false, 0},
+		{"", false, 0},
+		{"12345", false, 0},
+	}
+	for _, test := range tests {
+		got, ok := validTOTP(rfcSecret, test.code, now)
+		if ok != test.ok || got != test.step {
+			t.Errorf("validTOTP(%q) = %d, %v, want %d, %v", test.code, got, ok, test.step, test.ok)
+		}
+	}
+}
+
+func TestSecretPath(t *testing.T) {
+	c := &TOTPConfig{Secrets: "/etc/dockersshell/totp/%s"}
+	tests := []struct {
+		user string
+		want string
+	}{
+		{"alice", "/etc/dockersshell/totp/alice"},
+		{"john.doe", "/etc/dockersshell/totp/john.doe"},
+		{"_svc-1", "/etc/dockersshell/totp/_svc-1"},
+		{"../bob", ""},
+		{"..", ""},
+		{"a/b", ""},
+		{"Alice", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		got, err := c.secretPath(test.user)
+		if got != test.want || (err == nil) != (test.want != "") {
+			t.Errorf("secretPath(%q) = %q, %v, want %q", test.user, got, err, test.want)
+		}
+	}
+}
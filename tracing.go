@@ -0,0 +1,204 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type TracingConfig struct {
+	Endpoint string `yaml:"endpoint,omitempty"`
+	Insecure bool   `yaml:"insecure,omitempty"`
+}
+
+// span is a traced step, exported as an OTLP span once it has ended
+type span struct {
+	name   string
+	id     string
+	parent string
+	start  time.Time
+	end    time.Time
+	attrs  []string
+	err    string
+}
+
+// Spans are only recorded once initTracing has set the collector. The spans
+// of a launch all belong to traceID, as children of launchSpan.
+var (
+	traceURL   string
+	traceID    string
+	traceLock  sync.Mutex
+	launchSpan *span
+	finished   []*span
+)
+
+var tracingClient = &http.Client{Timeout: 2 * time.Second}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// initTracing exports spans as OTLP/HTTP JSON when a collector is configured
+// and returns a function exporting what is left on exit
+func initTracing(config *Config) func() {
+	if config.Tracing.Endpoint == "" {
+		return func() {}
+	}
+
+	scheme := "https"
+	if config.Tracing.Insecure {
+		scheme = "http"
+	}
+	traceURL = fmt.Sprintf("%s://%s/v1/traces", scheme, config.Tracing.Endpoint)
+	traceID = randomID(16)
+
+	return func() {
+		endTracing(nil)
+	}
+}
+
+func startLaunch(user string) {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	if traceURL != "" {
+		launchSpan = &span{name: "launch", id: randomID(8), start: time.Now(), attrs: []string{"user", user}}
+	}
+}
+
+// endLaunch ends the launch span and exports the launch
+func endLaunch() {
+	traceLock.Lock()
+	if launchSpan != nil {
+		launchSpan.end = time.Now()
+		finished = append(finished, launchSpan)
+		launchSpan = nil
+	}
+	traceLock.Unlock()
+	exportSpans()
+}
+
+// endTracing ends the launch, marked as failed when err is set, and exports
+// what has not been yet. fail calls it, so that failed launches are traced
+// too.
+func endTracing(err error) {
+	if err != nil {
+		traceLock.Lock()
+		if launchSpan != nil {
+			launchSpan.err = err.Error()
+		}
+		traceLock.Unlock()
+	}
+	endLaunch()
+}
+
+// startSpan starts a step of the launch, with attrs as key and value pairs
+func startSpan(name string, attrs ...string) *span {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	s := &span{name: name, id: randomID(8), start: time.Now(), attrs: attrs}
+	if launchSpan != nil {
+		s.parent = launchSpan.id
+	}
+	return s
+}
+
+func (s *span) End() {
+	traceLock.Lock()
+	defer traceLock.Unlock()
+	if traceURL != "" {
+		s.end = time.Now()
+		finished = append(finished, s)
+	}
+}
+
+// otlp returns the span in the JSON encoding of OTLP
+func (s *span) otlp() map[string]interface{} {
+	var attrs []interface{}
+	for i := 0; i+1 < len(s.attrs); i += 2 {
+		attrs = append(attrs, map[string]interface{}{
+			"key":   s.attrs[i],
+			"value": map[string]string{"stringValue": s.attrs[i+1]},
+		})
+	}
+	out := map[string]interface{}{
+		"traceId":           traceID,
+		"spanId":            s.id,
+		"name":              s.name,
+		"kind":              1,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        attrs,
+	}
+	if s.parent != "" {
+		out["parentSpanId"] = s.parent
+	}
+	if s.err != "" {
+		out["status"] = map[string]interface{}{"code": 2, "message": s.err}
+	}
+	return out
+}
+
+// exportSpans sends the spans that have ended to the collector
+func exportSpans() {
+	traceLock.Lock()
+	spans := finished
+	finished = nil
+	traceLock.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	var out []interface{}
+	for _, s := range spans {
+		out = append(out, s.otlp())
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []interface{}{map[string]interface{}{
+					"key":   "service.name",
+					"value": map[string]string{"stringValue": "dockersshell"},
+				}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "dockersshell"},
+				"spans": out,
+			}},
+		}},
+	})
+
+	resp, err := tracingClient.Post(traceURL, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("collector returned %s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.Print(msg("Unable to export traces: %s\n", err))
+	}
+}
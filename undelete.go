@@ -0,0 +1,193 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// deletedMarker joins the docker name of a soft deleted session and the time
+// it was deleted, in the name it is kept under
+const deletedMarker = ".deleted."
+
+// deletedContainers lists the soft deleted sessions on an endpoint
+func deletedContainers(client *docker.Client) ([]docker.APIContainers, error) {
+	containers, err := client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Limit:   -1,
+		Filters: map[string][]string{"label": {labelManaged + "=true"}, "status": {"exited"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []docker.APIContainers
+	for _, container := range containers {
+		if len(container.Names) == 1 && strings.Contains(container.Names[0], deletedMarker) {
+			deleted = append(deleted, container)
+		}
+	}
+	return deleted, nil
+}
+
+// deletedAt splits the name of a soft deleted session into its original
+// docker name and the time it was deleted
+func deletedAt(container docker.APIContainers) (string, time.Time) {
+	name := strings.TrimPrefix(container.Names[0], "/")
+	parts := strings.SplitN(name, deletedMarker, 2)
+	deleted, _ := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	return parts[0], time.Unix(deleted, 0)
+}
+
+// softDelete stops a session and renames it out of the way, for undelete to
+// restore until undelete_window has passed
+func softDelete(config *Config, client *docker.Client, id string, dockerName string) error {
+	deleted := fmt.Sprintf("%s%s%d", dockerName, deletedMarker, time.Now().Unix())
+	if err := client.RenameContainer(docker.RenameContainerOptions{ID: id, Name: deleted}); err != nil {
+		return err
+	}
+	until := time.Now().Add(time.Duration(config.UndeleteWindow) * time.Second)
+	status(colorGreen, "Removed session, undelete can restore it until %s", until.Format(time.RFC1123))
+	return nil
+}
+
+// purgeDeleted removes the soft deleted sessions on an endpoint whose
+// undelete window has passed
+func purgeDeleted(config *Config, client *docker.Client, endpoint string) []cleanResult {
+	containers, err := deletedContainers(client)
+	if err != nil {
+		return []cleanResult{{"failed", "-", endpoint, err.Error()}}
+	}
+
+	var results []cleanResult
+	window := time.Duration(config.UndeleteWindow) * time.Second
+	for _, container := range containers {
+		name, deleted := deletedAt(container)
+		if time.Since(deleted) < window {
+			continue
+		}
+		if err := client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID}); err != nil {
+			results = append(results, cleanResult{"failed", name, endpoint, msg("Unable to remove container: %s", err)})
+			continue
+		}
+		results = append(results, cleanResult{"purged", name, endpoint, fmt.Sprintf("deleted %s", deleted.Format(time.RFC3339))})
+	}
+	return results
+}
+
+// runUndelete restores a soft deleted session, or lists those that can be.
+// Restoring is creating a session as far as the checks go.
+func runUndelete(config *Config, user string, args []string) {
+	fs := findCommand("undelete").flags()
+	var opts sessionOptions
+	fs.StringVar(&opts.Reason, "reason", "", "Why you need the session, when a justification is required")
+	fs.StringVar(&opts.Ticket, "ticket", "", "Ticket the session is for, when a justification is required")
+	fs.Parse(args)
+	requireUser(user)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+
+	var endpoint string
+	var client *docker.Client
+	var found *docker.APIContainers
+	var foundAt time.Time
+	if name == "" {
+		fmt.Printf("%-30s %-30s %-20s %s\n", "NAME", "ENDPOINT", "DELETED", "PURGED")
+	}
+	for _, e := range config.Endpoints {
+		c, err := dockerClient(e)
+		if err != nil {
+			continue
+		}
+		containers, err := deletedContainers(c)
+		if err != nil {
+			log.Print(msg("Unable to list sessions on %s: %s\n", e, err))
+			continue
+		}
+		for i, container := range containers {
+			// Pooled sessions only carry their owner in their original name
+			original, deleted := deletedAt(container)
+			poolLabels(&docker.APIContainers{Names: []string{original}, Labels: container.Labels})
+			if container.Labels[labelOwner] != user {
+				continue
+			}
+			purged := deleted.Add(time.Duration(config.UndeleteWindow) * time.Second)
+			if name == "" {
+				fmt.Printf("%-30s %-30s %-20s %s\n", container.Labels[labelName], e, deleted.Format("2006-01-02 15:04:05"), purged.Format("2006-01-02 15:04:05"))
+				continue
+			}
+			if container.Labels[labelName] == name && (found == nil || deleted.After(foundAt)) {
+				endpoint, client, found, foundAt = e, c, &containers[i], deleted
+			}
+		}
+	}
+	if name == "" {
+		return
+	}
+
+	if found == nil {
+		log.Fatal(msg("No deleted session named %s", name))
+	}
+	if s := findSession(config.Endpoints, user, name); s != nil {
+		log.Fatal(msg("Session %s already exists on %s", name, s.Endpoint))
+	}
+	opts.Name, opts.Profile = name, found.Labels[labelProfile]
+	authorize(config, user, &opts)
+	states := []endpointState{{Endpoint: endpoint}}
+	applyBudget(config, states, user)
+	if states[0].Down {
+		log.Fatal(msg("Restoring %s would exceed the budget", name))
+	}
+
+	original, _ := deletedAt(*found)
+	if err := client.RenameContainer(docker.RenameContainerOptions{ID: found.ID, Name: original}); err != nil {
+		log.Fatal(msg("Unable to restore %s: %s\n", name, err))
+	}
+	if err := client.StartContainer(found.ID, nil); err != nil {
+		fail(ErrCreateFailed, msg("Unable to start container: %s\n", err))
+	}
+	if err := limitNetwork(client, found.ID, sessionProfile(config, found.Labels)); err != nil {
+		log.Print(msg("Unable to limit the network of %s: %s\n", name, err))
+	}
+	fields := map[string]string{"user": user, "session": name, "endpoint": endpoint, "detail": "undeleted"}
+	if opts.Reason != "" {
+		fields["justification"] = opts.Reason
+	}
+	if opts.Ticket != "" {
+		fields["ticket"] = opts.Ticket
+	}
+	audit(config, eventCreate, fields)
+	notify(config, eventCreate, "%s restored session %s on %s", user, name, endpoint)
+
+	host, port := sessionAddress(endpoint, client, found.ID)
+	registerSession(config, user, name, host, port)
+	wait(endpoint, host, port)
+	if publishHostKeys(client, found.ID, host, port) {
+		cacheSession(name, endpoint, found.ID, host, port, found.Labels)
+	}
+	status(colorGreen, "Restored %s", name)
+	fmt.Printf("%s %s %s\n", name, host, port)
+}
@@ -0,0 +1,214 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// UpdateConfig points self-update at a release directory holding
+// dockersshell-OS-ARCH binaries and a MANIFEST of their version and sha256
+// sums, with its base64 ed25519 signature in MANIFEST.sig
+type UpdateConfig struct {
+	URL       string `yaml:"url,omitempty"`
+	PublicKey string `yaml:"public_key,omitempty"`
+}
+
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+func fetch(url string) ([]byte, error) {
+	resp, err := updateClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// manifest is a signed release: its version and the sha256 sum of every
+// binary in it, in the form
+//
+//	version 1.4.0
+//	SHA256  dockersshell-linux-amd64
+type manifest struct {
+	Version string
+	Sums    map[string]string
+}
+
+func parseManifest(text []byte) (*manifest, error) {
+	m := &manifest{Sums: map[string]string{}}
+	for _, line := range strings.Split(string(text), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case len(fields) == 2 && fields[0] == "version":
+			m.Version = fields[1]
+		case len(fields) == 2:
+			m.Sums[fields[1]] = strings.ToLower(fields[0])
+		default:
+			return nil, fmt.Errorf("invalid manifest line %q", line)
+		}
+	}
+	if m.Version == "" {
+		return nil, fmt.Errorf("manifest has no version")
+	}
+	return m, nil
+}
+
+// compareVersions compares dotted versions numerically, returning a negative
+// number, zero or a positive number as a is older, the same as or newer than
+// b. Development builds are older than any release.
+func compareVersions(a string, b string) int {
+	if a == "dev" || b == "dev" {
+		switch {
+		case a == b:
+			return 0
+		case a == "dev":
+			return -1
+		}
+		return 1
+	}
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// selfUpdate downloads the latest release, verifies it against the signed
+// manifest and replaces the running binary with it. Releases older than the
+// running version are refused, so that a mirror cannot roll bastions back to
+// a vulnerable release, unless forced.
+func selfUpdate(c *UpdateConfig, force bool) error {
+	if c.URL == "" || c.PublicKey == "" {
+		return fmt.Errorf("update.url and update.public_key must be set")
+	}
+	key, err := base64.StdEncoding.DecodeString(c.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("update.public_key is not a base64 ed25519 public key")
+	}
+
+	base := strings.TrimRight(c.URL, "/")
+	text, err := fetch(base + "/MANIFEST")
+	if err != nil {
+		return err
+	}
+	encoded, err := fetch(base + "/MANIFEST.sig")
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), text, signature) {
+		return fmt.Errorf("signature of MANIFEST does not verify")
+	}
+	release, err := parseManifest(text)
+	if err != nil {
+		return err
+	}
+
+	latest := release.Version
+	if compare := compareVersions(latest, version); compare <= 0 && !force {
+		if compare == 0 {
+			status(colorGreen, "dockersshell %s is up to date", version)
+			return nil
+		}
+		return fmt.Errorf("release %s is older than the running %s, use -force to downgrade", latest, version)
+	}
+
+	name := fmt.Sprintf("dockersshell-%s-%s", runtime.GOOS, runtime.GOARCH)
+	sum, ok := release.Sums[name]
+	if !ok {
+		return fmt.Errorf("release %s has no %s", latest, name)
+	}
+	binary, err := fetch(base + "/" + name)
+	if err != nil {
+		return err
+	}
+	if digest := sha256.Sum256(binary); hex.EncodeToString(digest[:]) != sum {
+		return fmt.Errorf("sha256 of %s does not match the manifest", name)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if self, err = filepath.EvalSymlinks(self); err != nil {
+		return err
+	}
+
+	// Renaming within the directory replaces the binary atomically, so a
+	// concurrent login never runs a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(self), ".dockersshell-update")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), self); err != nil {
+		return err
+	}
+
+	status(colorGreen, "Updated dockersshell from %s to %s", version, latest)
+	return nil
+}
+
+func runSelfUpdate(config *Config, user string, args []string) {
+	fs := findCommand("self-update").flags()
+	force := fs.Bool("force", false, "Reinstall or downgrade even if the release is not newer")
+	fs.Parse(args)
+
+	if err := selfUpdate(&config.Update, *force); err != nil {
+		log.Fatal(msg("Unable to update: %s\n", err))
+	}
+}
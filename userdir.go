@@ -0,0 +1,109 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+)
+
+// userDirs are the directories under state_dir every user writes their own
+// files to. Like /tmp they are world writable and sticky, and they must be
+// owned by root so that no user can replace them or their permissions.
+func userDirs(config *Config) []string {
+	return []string{scheduleDir(config), sharedHistoryDir(config), auditDir(config), totpDir(config)}
+}
+
+// currentUser returns the name of the unix user dockersshell runs as, which
+// owns the files it writes to userDirs
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return invoker
+}
+
+// checkUserDir fails unless dir was set up by root
+func checkUserDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("%s is missing, run dockersshell setup as root", dir)
+	}
+	uid, ok := fileOwner(info)
+	if !info.IsDir() || !ok || uid != "0" || info.Mode()&os.ModeSticky == 0 {
+		return fmt.Errorf("%s is not a sticky directory owned by root, run dockersshell setup as root", dir)
+	}
+	return nil
+}
+
+// openUserFile opens a file in a user directory without following symlinks,
+// and fails unless what was opened is a regular file owned by owner
+func openUserFile(path string, owner string, flag int) (*os.File, error) {
+	f, err := openNoFollow(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil {
+		err = checkFileOwner(path, info, owner)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func checkFileOwner(path string, info os.FileInfo, owner string) error {
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return err
+	}
+	if uid, ok := fileOwner(info); !info.Mode().IsRegular() || !ok || uid != u.Uid {
+		return fmt.Errorf("%s is not a file owned by %s", path, owner)
+	}
+	return nil
+}
+
+// runSetup creates state_dir and the user directories in it owned by root,
+// taking over any a user created first
+func runSetup(config *Config, user string, args []string) {
+	findCommand("setup").flags().Parse(args)
+	if os.Geteuid() != 0 {
+		log.Fatal(msg("Setup has to run as root"))
+	}
+
+	if err := os.MkdirAll(config.stateDir(), 0755); err != nil {
+		log.Fatal(msg("Unable to create %s: %s\n", config.stateDir(), err))
+	}
+	for _, dir := range userDirs(config) {
+		if info, err := os.Lstat(dir); err == nil && !info.IsDir() {
+			os.Remove(dir)
+		}
+		err := os.Mkdir(dir, 0755)
+		if err == nil || os.IsExist(err) {
+			if err = os.Lchown(dir, 0, 0); err == nil {
+				err = os.Chmod(dir, os.ModeSticky|0777)
+			}
+		}
+		if err != nil {
+			log.Fatal(msg("Unable to create %s: %s\n", dir, err))
+		}
+		status(colorGreen, "Created %s", dir)
+	}
+}
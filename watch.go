@@ -0,0 +1,84 @@
+// Copyright 2014 Matt Martz <matt@sivel.net>
+// All Rights Reserved.
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// throttleWarnInterval limits how often a throttling warning is repeated
+const throttleWarnInterval = time.Minute
+
+// warnSession writes message to every terminal in the container
+func warnSession(client *docker.Client, id string, message string) {
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		Container: id,
+		User:      "root",
+		Cmd:       []string{"wall", message},
+	})
+	if err != nil {
+		log.Print(msg("Unable to warn session: %s\n", err))
+		return
+	}
+
+	if err := client.StartExec(exec.ID, docker.StartExecOptions{Detach: true}); err != nil {
+		log.Print(msg("Unable to warn session: %s\n", err))
+	}
+}
+
+// watchResources warns the session when the kernel OOM kills one of its
+// processes or its CPU is being throttled. It runs until done is closed.
+func watchResources(client *docker.Client, id string, done chan bool) {
+	events := make(chan *docker.APIEvents)
+	if err := client.AddEventListener(events); err != nil {
+		log.Print(msg("Unable to watch container events: %s\n", err))
+		return
+	}
+	defer client.RemoveEventListener(events)
+
+	stats := make(chan *docker.Stats)
+	go client.Stats(docker.StatsOptions{ID: id, Stats: stats, Stream: true, Done: done})
+
+	var throttled uint64
+	var warned time.Time
+	for {
+		select {
+		case <-done:
+			return
+		case event := <-events:
+			if event == nil {
+				return
+			}
+			if event.Actor.ID == id && (event.Action == "oom" || event.Status == "oom") {
+				warnSession(client, id, msg("dockersshell: a process in this session was killed because the session ran out of memory"))
+			}
+		case s, ok := <-stats:
+			if !ok {
+				stats = nil
+				continue
+			}
+			current := s.CPUStats.ThrottlingData.ThrottledPeriods
+			if throttled != 0 && current > throttled && time.Since(warned) > throttleWarnInterval {
+				warnSession(client, id, msg("dockersshell: this session is being CPU throttled because it reached its CPU limit"))
+				warned = time.Now()
+			}
+			throttled = current
+		}
+	}
+}